@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"arbiter/market"
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CredentialProvider picks the API credentials by source: file reads idFile and
+// secretFile, env PROBIT_<ACCOUNT>_ID/SECRET, keystore the account in keystore.
+func CredentialProvider(source string, account string, idFile string, secretFile string, keystore string) (market.CredentialProvider, error) {
+	switch source {
+	case "file":
+		return market.FileCredentials{IDFile: idFile, SecretFile: secretFile}, nil
+	case "env":
+		return market.EnvCredentials{Account: account}, nil
+	case "keystore":
+		return market.KeystoreCredentials{Path: keystore, Passphrase: ReadPassphrase(), Account: account}, nil
+	}
+	return nil, errors.New("unknown credential source: " + source)
+}
+
+// ReadPassphrase is PROBIT_KEYSTORE_PASSPHRASE, or else asked on the console.
+func ReadPassphrase() string {
+	if p := os.Getenv("PROBIT_KEYSTORE_PASSPHRASE"); p != "" {
+		return p
+	}
+	fmt.Print("keystore passphrase: ")
+	text, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimRight(text, "\r\n")
+}
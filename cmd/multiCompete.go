@@ -1,24 +1,38 @@
 package cmd
 
 import (
+	"arbiter/market"
+	"arbiter/market/fakeprobit"
+	"arbiter/multiTrade"
 	"fmt"
-	"prob/multiTrade"
 
+	"github.com/shopspring/decimal"
 	"github.com/spf13/cobra"
 )
 
 // multiCompeteCmd represents the multiCompete command
 var multiCompeteCmd = &cobra.Command{
 	Use:   "multiCompete",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
+	Short: "Run CompeteTrade on the pairs of a pair list",
+	Long: `Runs one CompeteTrade per entry of the JSON pair list in --file over one
+shared connection, with market data from the websocket or from HTTP polling
+(--protocol socket/http). Pairs given as arguments restrict the list.
 
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+The pair list is an array of objects with the NewCompeteTrade settings, e.g.
+[{"pair": "BTC-USDT", "buy": "30000", "sell": "40000", "usdQuantity": "20", "maxUSDBal": "200", "roughPrice": "35000"}]
+Optional keys: operation (controlled/auto/autoSell/none, switchable from the
+console), cageMinutes, cagePercent, minNetSpreadPercent, entryPrice, the
+ticker gates, and ladder ([{"offset": "0", "weight": "1"}, ...]) with
+ladderDriftPercent.
+
+The API credentials come from --cred: file (--idfile, --secretfile), env
+(PROBIT_ID/PROBIT_SECRET, or PROBIT_<ACCOUNT>_ID/SECRET with --account) or
+keystore (--account in --keystore, passphrase from PROBIT_KEYSTORE_PASSPHRASE or
+the console). --resturl, --authurl and --wsurl override the ProBit endpoints.
+
+With --fake the pairs trade on an in-process fake exchange (market/fakeprobit),
+each listed around its roughPrice, instead of ProBit.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("multiCompete called")
 		f, err := cmd.Flags().GetString("file")
 		if err != nil {
 			fmt.Println("error: ", err)
@@ -29,7 +43,15 @@ to quickly create a Cobra application.`,
 			fmt.Println("error: ", er)
 			return
 		}
-		multiTrade.MultiCompete(args, f, c)
+		opts, closeFake, err := commsOptions(cmd, f)
+		if err != nil {
+			fmt.Println("error: ", err)
+			return
+		}
+		defer closeFake()
+		if err = multiTrade.MultiCompete(args, f, c, opts); err != nil {
+			fmt.Println("error: ", err)
+		}
 
 	},
 }
@@ -48,4 +70,67 @@ func init() {
 	// multiCompeteCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	multiCompeteCmd.Flags().StringP("file", "f", "./default.json", "pair list file")
 	multiCompeteCmd.Flags().StringP("protocol", "t", "http", "protocol socket/http")
+	multiCompeteCmd.Flags().Bool("fake", false, "trade on an in-process fake exchange instead of ProBit")
+	multiCompeteCmd.Flags().String("cred", "file", "credential source: file/env/keystore")
+	multiCompeteCmd.Flags().String("account", "", "account name in the keystore or env (PROBIT_<ACCOUNT>_ID)")
+	multiCompeteCmd.Flags().String("idfile", "probID.txt", "client id file for --cred file")
+	multiCompeteCmd.Flags().String("secretfile", "probSecret.txt", "client secret file for --cred file")
+	multiCompeteCmd.Flags().String("keystore", "probKeystore.json", "keystore file for --cred keystore")
+	multiCompeteCmd.Flags().String("resturl", "", "REST endpoint instead of ProBit's")
+	multiCompeteCmd.Flags().String("authurl", "", "token endpoint instead of ProBit's")
+	multiCompeteCmd.Flags().String("wsurl", "", "websocket endpoint instead of ProBit's")
+}
+
+// commsOptions builds the Comms options from the flags; with --fake they point at a
+// fake exchange with the pairs of file, closed by the returned func.
+func commsOptions(cmd *cobra.Command, file string) (market.CommsOptions, func(), error) {
+	flags := cmd.Flags()
+	if fake, _ := flags.GetBool("fake"); fake {
+		s, err := fakeExchange(file)
+		if err != nil {
+			return market.CommsOptions{}, nil, err
+		}
+		return s.CommsOptions(), s.Close, nil
+	}
+	opts := market.DefaultCommsOptions()
+	if v, _ := flags.GetString("resturl"); v != "" {
+		opts.RestURL = v
+	}
+	if v, _ := flags.GetString("authurl"); v != "" {
+		opts.AuthURL = v
+	}
+	if v, _ := flags.GetString("wsurl"); v != "" {
+		opts.WsURL = v
+	}
+	source, _ := flags.GetString("cred")
+	account, _ := flags.GetString("account")
+	idFile, _ := flags.GetString("idfile")
+	secretFile, _ := flags.GetString("secretfile")
+	keystore, _ := flags.GetString("keystore")
+	creds, err := CredentialProvider(source, account, idFile, secretFile, keystore)
+	if err != nil {
+		return market.CommsOptions{}, nil, err
+	}
+	opts.Credentials = creds
+	return opts, func() {}, nil
+}
+
+// fakeExchange starts a fake exchange with the pairs of the pair list file.
+func fakeExchange(file string) (*fakeprobit.Server, error) {
+	configs, err := multiTrade.LoadPairs(file)
+	if err != nil {
+		return nil, err
+	}
+	s := fakeprobit.New()
+	for _, p := range configs {
+		mid := p.RoughPrice
+		if !mid.IsPositive() {
+			mid = decimal.NewFromInt(100)
+		}
+		if err = s.AddDemoMarket(p.Pair, mid); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
 }
@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// rootCmd is arbiter without a command: the interactive console of main.go.
+var rootCmd = &cobra.Command{
+	Use:   "arbiter",
+	Short: "ProBit market maker",
+}
+
+// Execute runs the command of os.Args.
+func Execute() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
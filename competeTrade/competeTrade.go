@@ -1,9 +1,10 @@
 package competeTrade
 
 import (
+	"arbiter/market"
+	"errors"
 	"fmt"
 	"log"
-	"prob/market"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -12,12 +13,82 @@ import (
 type operationT int
 
 const (
-	opControlledTrade operationT = iota
-	opAutoTrade
-	opAutoSell
-	opNone
+	opControlledTrade operationT = iota //like auto trade, but new orders wait for Confirm
+	opAutoTrade                         //compete on both sides
+	opAutoSell                          //only sell, down to MinBalance; own buys are cancelled
+	opNone                              //just observe, own orders are left as they are
 )
 
+var operationNames = map[operationT]string{
+	opControlledTrade: "controlled",
+	opAutoTrade:       "auto",
+	opAutoSell:        "autoSell",
+	opNone:            "none",
+}
+
+func (o operationT) String() string {
+	return operationNames[o]
+}
+
+// SetOperation switches the mode by name: controlled, auto, autoSell or none.
+// At runtime, call it on the pair's goroutine (MarketPair.Do).
+func (o *CompeteTrade) SetOperation(name string) error {
+	for op, n := range operationNames {
+		if n == name {
+			if op != o.Operation {
+				o.warnLog.Println(o.Pair, "operation", o.Operation, "->", op)
+			}
+			o.Operation = op
+			o.proposed = nil
+			return nil
+		}
+	}
+	return errors.New("unknown operation: " + name)
+}
+
+// Confirm puts the proposed orders in controlled trade, a sell before a buy as in
+// CallBackHttp. The book is checked again: an order is only placed if it is still
+// the same as its proposal, otherwise the changed order is proposed instead and
+// needs its own Confirm. False if no order was placed.
+func (o *CompeteTrade) Confirm(m *market.MarketPair) bool {
+	if o.Operation != opControlledTrade {
+		o.warnLog.Println(o.Pair, "nothing to confirm in", o.Operation, "operation")
+		return false
+	}
+	o.confirmed = true
+	defer func() { o.confirmed = false }()
+	buy, sell := o.lastBuy.ID, o.lastSell.ID
+	o.CallBackHttp(m)
+	if o.lastBuy.ID == buy && o.lastSell.ID == sell {
+		o.warnLog.Println(o.Pair, "confirmed, but there is no order to put now")
+		return false
+	}
+	return true
+}
+
+// release is false if a new order has to wait for Confirm; it then becomes the
+// proposal of key, the side or the side and ladder level. During Confirm only the
+// order equal to the proposal of its key is released.
+func (o *CompeteTrade) release(key string, r market.Order) bool {
+	if o.Operation != opControlledTrade {
+		delete(o.proposed, key)
+		return true
+	}
+	p, found := o.proposed[key]
+	if o.confirmed && found && p == r {
+		delete(o.proposed, key)
+		return true
+	}
+	if o.proposed == nil {
+		o.proposed = make(map[string]market.Order)
+	}
+	if !found || p != r {
+		o.warnLog.Println(o.Pair, "proposed", r.Side, r.Quantity, "at", r.LimitPrice, "- confirm to put it")
+	}
+	o.proposed[key] = r
+	return false
+}
+
 type CompeteTrade struct {
 	Operation   operationT
 	Pair        string
@@ -32,37 +103,237 @@ type CompeteTrade struct {
 	MinUSDBal  decimal.Decimal
 	RoughPrice decimal.Decimal
 	cage       safetyCage
-	infoLog    *log.Logger
-	warnLog    *log.Logger
-	errLog     *log.Logger
+	//ticker gates, zero disables each: no new orders when the 24h market is outside them
+	MinQuoteVolume   decimal.Decimal //24h volume in the quote coin
+	MaxRangePercent  decimal.Decimal //24h (high-low)/low
+	MaxChangePercent decimal.Decimal //absolute 24h change
+	gated            string          //why the last ticker closed the gates, empty if open
+	//safety cage, see safetyCage; zero CageWindow disables it
+	CageWindow  time.Duration
+	CagePercent decimal.Decimal
+	OnCageTrip  func(t CageTrip) //optional, called on the pair's goroutine
+	//net spread: a round trip of our buy and sell must earn this after the maker fees.
+	//Sells are measured against the average entry cost of the coin bought since start,
+	//or EntryPrice before the first buy, or else the bid side.
+	MinNetSpreadPercent decimal.Decimal
+	EntryPrice          decimal.Decimal //optional entry cost of the coin held at start
+	heldQuantity        decimal.Decimal //bought since start, less sold
+	heldCost            decimal.Decimal //quote paid for heldQuantity, with fees
+	noFeeRate           bool            //the missing fee rate was reported
+	//ladder mode with one order per level and side; empty for a single order per side
+	Ladder             []LadderLevel
+	LadderDriftPercent decimal.Decimal
+	//in controlled trade, the order waiting for confirmation and whether it is given
+	proposed  map[string]market.Order //by side, or side and ladder level
+	confirmed bool
+	//the orders placed last, as returned by the exchange
+	lastBuy  market.CurrentOrder
+	lastSell market.CurrentOrder
+	//no order actions before this, set after rate limit or auth failures
+	backoffUntil time.Time
+	infoLog      *log.Logger
+	warnLog      *log.Logger
+	errLog       *log.Logger
 }
 
+const rateBackoff = 30 * time.Second
+const authBackoff = 10 * time.Second
+
+// defaults of CageWindow and CagePercent
 const CageMinutes = 1
 const CagePerCentLimit = 2
 
+// safetyCage keeps our quotes from following a runaway book: within the window after
+// an order, a new buy may be at most the percent above it and a new sell at most the
+// percent below it.
 type safetyCage struct {
 	cageEndTimeBuy  time.Time
 	cagePriceBuy    decimal.Decimal
 	cageEndTimeSell time.Time
 	cagePriceSell   decimal.Decimal
+	//end of the cage period of the last reported trip per side, to report each only once
+	tripEndBuy  time.Time
+	tripEndSell time.Time
 }
 
 func (o *safetyCage) allowBuy(propsedPrice decimal.Decimal) bool {
-	if time.Now().Before(o.cageEndTimeBuy) && propsedPrice.GreaterThan(o.cagePriceBuy) {
+	return !time.Now().Before(o.cageEndTimeBuy) || !propsedPrice.GreaterThan(o.cagePriceBuy)
+}
+
+func (o *safetyCage) allowSell(propsedPrice decimal.Decimal) bool {
+	return !time.Now().Before(o.cageEndTimeSell) || !propsedPrice.LessThan(o.cagePriceSell)
+}
+
+// placedBuy starts a cage period around a buy put at price.
+func (o *safetyCage) placedBuy(price decimal.Decimal, window time.Duration, percent decimal.Decimal) {
+	o.cageEndTimeBuy = time.Now().Add(window)
+	o.cagePriceBuy = price.Mul(decimal.NewFromInt(1).Add(percent.Div(decimal.NewFromInt(100))))
+}
+
+func (o *safetyCage) placedSell(price decimal.Decimal, window time.Duration, percent decimal.Decimal) {
+	o.cageEndTimeSell = time.Now().Add(window)
+	o.cagePriceSell = price.Mul(decimal.NewFromInt(1).Sub(percent.Div(decimal.NewFromInt(100))))
+}
+
+// CageTrip is an order the safety cage stopped: Price is beyond Limit until Until.
+type CageTrip struct {
+	Pair  string
+	Side  string
+	Price decimal.Decimal
+	Limit decimal.Decimal
+	Until time.Time
+}
+
+// cageAllows checks a new order against the cage and reports the first trip of each cage period.
+func (o *CompeteTrade) cageAllows(side string, price decimal.Decimal) bool {
+	if o.CageWindow <= 0 {
+		return true
+	}
+	t := CageTrip{Pair: o.Pair, Side: side, Price: price}
+	var reported *time.Time
+	if side == "buy" {
+		if o.cage.allowBuy(price) {
+			return true
+		}
+		t.Limit, t.Until, reported = o.cage.cagePriceBuy, o.cage.cageEndTimeBuy, &o.cage.tripEndBuy
+	} else {
+		if o.cage.allowSell(price) {
+			return true
+		}
+		t.Limit, t.Until, reported = o.cage.cagePriceSell, o.cage.cageEndTimeSell, &o.cage.tripEndSell
+	}
+	if reported.Equal(t.Until) {
+		o.infoLog.Println(o.Pair, "safety cage still holding", side, "at", price)
 		return false
 	}
-	o.cageEndTimeBuy = time.Now().Add(time.Minute * CageMinutes)
-	o.cagePriceBuy = propsedPrice.Mul(decimal.NewFromFloat32(0.01*CagePerCentLimit + 1))
-	return true
+	*reported = t.Until
+	o.warnLog.Println(o.Pair, "safety cage tripped:", side, "at", price, "beyond", t.Limit.StringFixed(8), "until", t.Until.Format("15:04:05"))
+	if o.OnCageTrip != nil {
+		o.OnCageTrip(t)
+	}
+	return false
 }
 
-func (o *safetyCage) allowSell(propsedPrice decimal.Decimal) bool {
-	if time.Now().Before(o.cageEndTimeSell) && propsedPrice.LessThan(o.cagePriceSell) {
+// OnTicker checks the ticker gates.
+func (o *CompeteTrade) OnTicker(m *market.MarketPair, t market.TickerStats) {
+	reason := o.tickerGate(t)
+	if reason != o.gated {
+		if reason == "" {
+			o.warnLog.Println(o.Pair, "ticker gates open")
+		} else {
+			o.warnLog.Println(o.Pair, "ticker gates closed:", reason)
+		}
+	}
+	o.gated = reason
+}
+
+// tickerGate is the reason for not trading on this ticker, empty if trading is fine.
+func (o *CompeteTrade) tickerGate(t market.TickerStats) string {
+	if !o.MinQuoteVolume.IsZero() && t.QuoteVolume.LessThan(o.MinQuoteVolume) {
+		return "24h volume " + t.QuoteVolume.String() + " below " + o.MinQuoteVolume.String()
+	}
+	if r := t.RangePercent(); !o.MaxRangePercent.IsZero() && r.GreaterThan(o.MaxRangePercent) {
+		return "24h range " + r.StringFixed(2) + "% above " + o.MaxRangePercent.String() + "%"
+	}
+	if c := t.ChangePercent().Abs(); !o.MaxChangePercent.IsZero() && c.GreaterThan(o.MaxChangePercent) {
+		return "24h change " + c.StringFixed(2) + "% above " + o.MaxChangePercent.String() + "%"
+	}
+	return ""
+}
+
+// gatesOpen is false if a ticker gate is set and the ticker is outside it or not known yet.
+func (o *CompeteTrade) gatesOpen(m *market.MarketPair) bool {
+	if o.MinQuoteVolume.IsZero() && o.MaxRangePercent.IsZero() && o.MaxChangePercent.IsZero() {
+		return true
+	}
+	if _, found := m.Ticker(); !found {
+		o.infoLog.Println(o.Pair, "no ticker yet, not trading")
 		return false
 	}
-	o.cageEndTimeSell = time.Now().Add(time.Minute * CageMinutes)
-	o.cagePriceSell = propsedPrice.Mul(decimal.NewFromFloat32(-0.01*CagePerCentLimit + 1))
-	return true
+	return o.gated == ""
+}
+
+var one = decimal.NewFromInt(1)
+var hundred = decimal.NewFromInt(100)
+
+// feeRate is the maker fee of the pair as a fraction; ProBit sends percents. Our
+// orders never cross the book, so both legs of a round trip pay the maker fee.
+func (o *CompeteTrade) feeRate(m *market.MarketPair) decimal.Decimal {
+	for _, r := range []string{m.Spec.MakerFeeRate, m.Spec.TakerFeeRate} {
+		if f, err := decimal.NewFromString(r); err == nil {
+			return f.Div(hundred)
+		}
+	}
+	if !o.noFeeRate {
+		o.errLog.Println(o.Pair, "no fee rate in the market spec, assuming zero")
+		o.noFeeRate = true
+	}
+	return decimal.Zero
+}
+
+// minSellPrice is the lowest sell that earns MinNetSpreadPercent over cost, the fee
+// inclusive price of the coin (see entryRef), after the sell fee.
+func (o *CompeteTrade) minSellPrice(cost decimal.Decimal, fee decimal.Decimal) decimal.Decimal {
+	return cost.Mul(one.Add(o.MinNetSpreadPercent.Div(hundred))).Div(one.Sub(fee))
+}
+
+// maxBuyPrice is the highest buy that earns MinNetSpreadPercent on a sell at sell after fees.
+func (o *CompeteTrade) maxBuyPrice(sell decimal.Decimal, fee decimal.Decimal) decimal.Decimal {
+	return sell.Mul(one.Sub(fee)).Div(one.Add(fee)).Div(one.Add(o.MinNetSpreadPercent.Div(hundred)))
+}
+
+// AvgEntryCost is the fee inclusive average price of the coin bought since start and
+// not sold yet, or EntryPrice; false if neither is known.
+func (o *CompeteTrade) AvgEntryCost() (decimal.Decimal, bool) {
+	if o.heldQuantity.IsPositive() {
+		return o.heldCost.Div(o.heldQuantity), true
+	}
+	return o.EntryPrice, o.EntryPrice.IsPositive()
+}
+
+// entryRef is the fee inclusive cost a sell is measured against, and where it comes
+// from. The entry cost has the buy fee in already; the buy fee is added to a buy price.
+func (o *CompeteTrade) entryRef(m *market.MarketPair, fee decimal.Decimal) (decimal.Decimal, string) {
+	if c, found := o.AvgEntryCost(); found {
+		return c, "entry cost"
+	}
+	if !m.MyHighestBuy.Price.IsZero() {
+		return m.MyHighestBuy.Price.Mul(one.Add(fee)), "my buy"
+	}
+	return m.MarketHighestBuy.Price.Add(m.GetIncrement()).Mul(one.Add(fee)), "next buy"
+}
+
+// track updates the held quantity and its cost with own fills.
+func (o *CompeteTrade) track(m *market.MarketPair, t market.MyTrade) {
+	p, e1 := decimal.NewFromString(t.Price)
+	q, e2 := decimal.NewFromString(t.Quantity)
+	fee, e3 := decimal.NewFromString(t.FeeAmount)
+	if e1 != nil || e2 != nil {
+		o.errLog.Println(o.Pair, "bad fill, entry cost not updated:", t)
+		return
+	}
+	if e3 != nil {
+		fee = decimal.Zero
+	}
+	if t.Side == "buy" {
+		o.heldCost = o.heldCost.Add(p.Mul(q))
+		if t.FeeCurrencyID == m.Coin {
+			q = q.Sub(fee)
+		} else {
+			o.heldCost = o.heldCost.Add(fee)
+		}
+		o.heldQuantity = o.heldQuantity.Add(q)
+		return
+	}
+	if !o.heldQuantity.IsPositive() {
+		return //sold what was held before start
+	}
+	if q.GreaterThanOrEqual(o.heldQuantity) {
+		o.heldQuantity, o.heldCost = decimal.Zero, decimal.Zero
+		return
+	}
+	o.heldCost = o.heldCost.Sub(o.heldCost.Div(o.heldQuantity).Mul(q))
+	o.heldQuantity = o.heldQuantity.Sub(q)
 }
 
 func NewCompeteTrade(p string, b decimal.Decimal, s decimal.Decimal,
@@ -97,6 +368,9 @@ func NewCompeteTrade(p string, b decimal.Decimal, s decimal.Decimal,
 	t := CompeteTrade{Pair: p, Buy: b, Sell: s, Quantity: q, USDQuantity: u,
 		MaxBalance: max, MinBalance: min,
 		MaxUSDBal: maxu, MinUSDBal: minu, RoughPrice: roughP,
+		Operation:          opAutoTrade,
+		LadderDriftPercent: decimal.NewFromFloat(DefaultLadderDrift),
+		CageWindow:         CageMinutes * time.Minute, CagePercent: decimal.NewFromInt(CagePerCentLimit),
 		infoLog: info, warnLog: warn, errLog: er}
 
 	if t.Quantity.IsZero() && t.USDQuantity.IsZero() {
@@ -114,6 +388,9 @@ func (o *CompeteTrade) sellPutCheck(m *market.MarketPair) bool {
 		o.infoLog.Println("not selling")
 		return false
 	}
+	if !o.gatesOpen(m) {
+		return false
+	}
 	s := m.MarketLowestSell.Price
 	s = s.Sub(m.GetIncrement())
 	o.infoLog.Println(o.Pair, "sell check:", s)
@@ -121,6 +398,14 @@ func (o *CompeteTrade) sellPutCheck(m *market.MarketPair) bool {
 		o.infoLog.Println("no suitable sell price", s)
 		return false
 	}
+	if o.Operation != opAutoSell { //liquidation sells at any spread
+		fee := o.feeRate(m)
+		ref, from := o.entryRef(m, fee)
+		if min := o.minSellPrice(ref, fee); s.LessThan(min) {
+			o.infoLog.Println(o.Pair, "sell at", s, "below the net spread over the", from, "cost", ref.StringFixed(8), "- needs", min.StringFixed(8))
+			return false
+		}
+	}
 	var qToSell decimal.Decimal
 	if !o.Quantity.IsZero() {
 		qToSell = o.Quantity
@@ -137,6 +422,9 @@ func (o *CompeteTrade) sellPutCheck(m *market.MarketPair) bool {
 		o.infoLog.Println("no suitable sell quantity", q)
 		return false
 	}
+	if !o.cageAllows("sell", s) {
+		return false
+	}
 	r := market.Order{
 		LimitPrice:  s.String(),
 		MarketID:    o.Pair,
@@ -146,8 +434,17 @@ func (o *CompeteTrade) sellPutCheck(m *market.MarketPair) bool {
 		Type:        "limit",
 		//	r.ClientOrderID = "testsell"
 	}
-	m.NewOrder(r)
-	o.infoLog.Println("put sell at:", r.LimitPrice)
+	if !o.release(r.Side, r) {
+		return false
+	}
+	c, err := m.NewOrder(r)
+	if err != nil {
+		o.orderFailed("put sell", err)
+		return false
+	}
+	o.lastSell = c
+	o.cage.placedSell(s, o.CageWindow, o.CagePercent)
+	o.infoLog.Println("put sell at:", r.LimitPrice, "id:", c.ID, "open:", c.OpenQuantity)
 	return true
 }
 func (o *CompeteTrade) buyPutCheck(m *market.MarketPair) bool {
@@ -155,6 +452,9 @@ func (o *CompeteTrade) buyPutCheck(m *market.MarketPair) bool {
 		o.infoLog.Println("not buying")
 		return false
 	}
+	if !o.gatesOpen(m) {
+		return false
+	}
 	b := m.MarketHighestBuy.Price
 	b = b.Add(m.GetIncrement())
 	o.infoLog.Println(o.Pair, "buy check:", b)
@@ -162,6 +462,14 @@ func (o *CompeteTrade) buyPutCheck(m *market.MarketPair) bool {
 		o.infoLog.Println("no suitable buy price", b)
 		return false
 	}
+	ref, from := m.MyLowestSell.Price, "my sell"
+	if ref.IsZero() {
+		ref, from = m.MarketLowestSell.Price.Sub(m.GetIncrement()), "next sell"
+	}
+	if max := o.maxBuyPrice(ref, o.feeRate(m)); b.GreaterThan(max) {
+		o.infoLog.Println(o.Pair, "buy at", b, "above the net spread under", from, ref, "- needs", max.StringFixed(8))
+		return false
+	}
 	var qToBuy decimal.Decimal
 	if !o.Quantity.IsZero() {
 		qToBuy = o.Quantity
@@ -177,6 +485,9 @@ func (o *CompeteTrade) buyPutCheck(m *market.MarketPair) bool {
 		o.infoLog.Println("no suitable buy quantity", q)
 		return false
 	}
+	if !o.cageAllows("buy", b) {
+		return false
+	}
 	r := market.Order{
 		LimitPrice:  b.String(),
 		MarketID:    o.Pair,
@@ -187,25 +498,32 @@ func (o *CompeteTrade) buyPutCheck(m *market.MarketPair) bool {
 		//	r.ClientOrderID = "testsell"
 		//	r.Cost = "3.37"
 	}
-	m.NewOrder(r)
-	o.infoLog.Println("put buy at:", r.LimitPrice)
+	if !o.release(r.Side, r) {
+		return false
+	}
+	c, err := m.NewOrder(r)
+	if err != nil {
+		o.orderFailed("put buy", err)
+		return false
+	}
+	o.lastBuy = c
+	o.cage.placedBuy(b, o.CageWindow, o.CagePercent)
+	o.infoLog.Println("put buy at:", r.LimitPrice, "id:", c.ID, "open:", c.OpenQuantity)
 	return true
 }
 
 func (o *CompeteTrade) sellOutbidCheck(m *market.MarketPair) bool {
 	if !o.Sell.IsZero() && m.MyLowestSell.Price.GreaterThan(m.MarketLowestSell.Price) {
-		o.infoLog.Println("outbid sell canceling", m.MyLowestSell.Price, m.MarketLowestSell.Price)
-		m.CancelOrders("sell") //just cancel the existing order.
-		//On the next notification caused by this cancelation, put the new order
+		o.infoLog.Println("outbid sell repricing", m.MyLowestSell.Price, m.MarketLowestSell.Price)
+		o.moveOrder(m, "sell")
 		return true
 	}
 	return false
 }
 func (o *CompeteTrade) buyOutbidCheck(m *market.MarketPair) bool {
 	if !o.Buy.IsZero() && !m.MyHighestBuy.Price.IsZero() && m.MyHighestBuy.Price.LessThan(m.MarketHighestBuy.Price) {
-		o.infoLog.Println("outbid buy canceling", m.MyHighestBuy.Price, m.MarketHighestBuy.Price)
-		m.CancelOrders("buy") //just cancel the existing order.
-		//On the next notification caused by this cancelation, put the new order
+		o.infoLog.Println("outbid buy repricing", m.MyHighestBuy.Price, m.MarketHighestBuy.Price)
+		o.moveOrder(m, "buy")
 		return true
 	}
 	return false
@@ -219,10 +537,10 @@ func (o *CompeteTrade) sellGapCheck(m *market.MarketPair) bool {
 	p := m.MyLowestSell.Price
 	p = p.Add(m.GetIncrement())
 	if !p.Equal(m.MarketLowestSell.Price) && !p.Equal(m.Market2ndSell.Price) {
-		m.CancelOrders("sell")
-		o.infoLog.Println(o.Pair, "Cancel sells to fill the gap", m.MyLowestSell.Price, "+", m.GetIncrement(), p)
+		o.infoLog.Println(o.Pair, "Reprice sells to fill the gap", m.MyLowestSell.Price, "+", m.GetIncrement(), p)
+		o.moveOrder(m, "sell")
 		return true
-	} //for now, just cancel the existing order.On the next notification caused by this cancelation, put the new order
+	}
 	return false
 }
 func (o *CompeteTrade) buyGapCheck(m *market.MarketPair) bool {
@@ -234,14 +552,157 @@ func (o *CompeteTrade) buyGapCheck(m *market.MarketPair) bool {
 	p := m.MyHighestBuy.Price
 	p = p.Sub(m.GetIncrement())
 	if !p.Equal(m.MarketHighestBuy.Price) && !p.Equal(m.Market2ndBuy.Price) {
-		m.CancelOrders("buy")
-		o.infoLog.Println(o.Pair, "Cancel buys to fill the gap", m.MyHighestBuy.Price, "-", m.GetIncrement(), p)
+		o.infoLog.Println(o.Pair, "Reprice buys to fill the gap", m.MyHighestBuy.Price, "-", m.GetIncrement(), p)
+		o.moveOrder(m, "buy")
 		return true
-	} //for now, just cancel the existing order.On the next notification caused by this cancelation, put the new order
+	}
 	return false
 }
+
+// competingPrice is one increment better than the best rival on side, if it is
+// within the Buy/Sell limits and the net spread and doesn't cross the book.
+func (o *CompeteTrade) competingPrice(m *market.MarketPair, side string) (decimal.Decimal, bool) {
+	rival, found := rivalBest(m, side)
+	if !found {
+		return decimal.Zero, false
+	}
+	fee := o.feeRate(m)
+	inc := m.GetIncrement()
+	if side == "buy" {
+		p := rival.Add(inc)
+		ref := m.MyLowestSell.Price
+		if ref.IsZero() {
+			ref = m.MarketLowestSell.Price.Sub(inc)
+		}
+		return p, !p.GreaterThan(o.Buy) && p.LessThan(m.MarketLowestSell.Price) && !p.GreaterThan(o.maxBuyPrice(ref, fee))
+	}
+	p := rival.Sub(inc)
+	ok := !p.LessThan(o.Sell) && p.GreaterThan(m.MarketHighestBuy.Price)
+	if o.Operation != opAutoSell {
+		ref, _ := o.entryRef(m, fee)
+		ok = ok && !p.LessThan(o.minSellPrice(ref, fee))
+	}
+	return p, ok
+}
+
+// moveOrder reprices our order on side to the competing price in one step. It falls
+// back to canceling the side, for the put checks to place a new order on the next
+// update, if there is not exactly one order, no valid price or the safety cage
+// holds, and always in controlled trade, where the new order needs its confirmation.
+func (o *CompeteTrade) moveOrder(m *market.MarketPair, side string) {
+	var ids []string
+	for _, d := range m.MyOrders {
+		if d.Side == side && d.MarketID == o.Pair {
+			ids = append(ids, d.ID)
+		}
+	}
+	price, ok := o.competingPrice(m, side)
+	if len(ids) != 1 || !ok || o.Operation == opControlledTrade || !o.cageAllows(side, price) {
+		if err := m.CancelOrders(side); err != nil {
+			o.orderFailed("cancel "+side, err)
+		}
+		return
+	}
+	c, err := m.Reprice(ids[0], price)
+	if err != nil {
+		o.orderFailed("reprice "+side, err)
+		return
+	}
+	if side == "buy" {
+		o.lastBuy = c
+		o.cage.placedBuy(price, o.CageWindow, o.CagePercent)
+	} else {
+		o.lastSell = c
+		o.cage.placedSell(price, o.CageWindow, o.CagePercent)
+	}
+	o.infoLog.Println(o.Pair, "repriced", side, "to:", c.LimitPrice, "id:", c.ID, "open:", c.OpenQuantity)
+}
+
+// orderFailed reacts to a rejected order action according to the ProBit error.
+func (o *CompeteTrade) orderFailed(action string, err error) {
+	switch {
+	case errors.Is(err, market.ErrInsufficientBalance):
+		o.infoLog.Println(o.Pair, action, "not enough balance")
+	case errors.Is(err, market.ErrRateLimited):
+		o.backoffUntil = time.Now().Add(rateBackoff)
+		o.warnLog.Println(o.Pair, action, "rate limited, backing off until", o.backoffUntil.Format("15:04:05"))
+	case errors.Is(err, market.ErrUnauthorized):
+		o.backoffUntil = time.Now().Add(authBackoff)
+		o.errLog.Println(o.Pair, action, "unauthorized, waiting for a new token:", err)
+	case errors.Is(err, market.ErrOrderNotFound):
+		o.infoLog.Println(o.Pair, action, "order already gone:", err)
+	case errors.Is(err, market.ErrRepriceTooSmall):
+		o.infoLog.Println(o.Pair, action, "rest too small, left out:", err)
+	default:
+		o.errLog.Println(o.Pair, action, "failed:", err)
+	}
+}
+
+var _ market.Strategy = (*CompeteTrade)(nil)
+var _ market.TickerStrategy = (*CompeteTrade)(nil)
+
+// OnBookUpdate competes for the edges of the new book.
+func (o *CompeteTrade) OnBookUpdate(m *market.MarketPair) {
+	o.CallBackHttp(m)
+}
+
+// OnOwnOrderUpdate puts a new order after one was filled or cancelled.
+func (o *CompeteTrade) OnOwnOrderUpdate(m *market.MarketPair, orders []market.CurrentOrder) {
+	if m.BookTrusted() {
+		o.CallBackHttp(m)
+	}
+}
+
+// OnFill logs own fills and keeps the average entry cost.
+func (o *CompeteTrade) OnFill(m *market.MarketPair, trades []market.MyTrade) {
+	for _, t := range trades {
+		o.warnLog.Println(o.Pair, "filled:", t.Side, t.Quantity, "at", t.Price)
+		o.track(m, t)
+	}
+	if c, found := o.AvgEntryCost(); found {
+		o.infoLog.Println(o.Pair, "average entry cost:", c.StringFixed(8))
+	}
+}
+
+// OnBalanceChange is ignored: the put checks read the balance when they need it.
+func (o *CompeteTrade) OnBalanceChange(m *market.MarketPair, currency string, b market.CoinBalance) {
+}
+
+// OnTimer retries after a backoff ends on a quiet market.
+func (o *CompeteTrade) OnTimer(m *market.MarketPair, t time.Time) {
+	if !o.backoffUntil.IsZero() && t.After(o.backoffUntil) && m.BookTrusted() {
+		o.backoffUntil = time.Time{}
+		o.CallBackHttp(m)
+	}
+}
+
+func (o *CompeteTrade) OnDisconnect(m *market.MarketPair) {
+	o.warnLog.Println(o.Pair, "socket lost, waiting for a new book")
+}
+
 func (o *CompeteTrade) CallBackHttp(m *market.MarketPair) {
+	if time.Now().Before(o.backoffUntil) {
+		o.infoLog.Println(o.Pair, "backing off until", o.backoffUntil.Format("15:04:05"))
+		return
+	}
 	o.infoLog.Println(o.Pair, "callbackHttp: my:", m.MyLowestSell.Price, m.MyHighestBuy.Price, "market:", m.Market2ndSell, m.MarketLowestSell.Price, m.MarketHighestBuy.Price, m.Market2ndBuy)
+	if o.Operation == opNone {
+		return
+	}
+	if o.Operation == opAutoSell && !m.MyHighestBuy.Price.IsZero() {
+		o.infoLog.Println(o.Pair, "auto sell, canceling buys")
+		if err := m.CancelOrders("buy"); err != nil {
+			o.orderFailed("cancel buy", err)
+		}
+		return
+	}
+	if len(o.Ladder) > 0 {
+		if o.ladderSide(m, "sell") || o.Operation == opAutoSell {
+			return
+		}
+		o.ladderSide(m, "buy")
+		return
+	}
 	if o.sellPutCheck(m) {
 		return
 	}
@@ -251,6 +712,9 @@ func (o *CompeteTrade) CallBackHttp(m *market.MarketPair) {
 	if o.sellGapCheck(m) {
 		return
 	}
+	if o.Operation == opAutoSell {
+		return
+	}
 	if o.buyPutCheck(m) {
 		return
 	}
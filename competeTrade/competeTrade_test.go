@@ -0,0 +1,84 @@
+package competeTrade
+
+import (
+	"arbiter/market"
+	"io/ioutil"
+	"log"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestMinSellPrice(t *testing.T) {
+	fee := decimal.RequireFromString("0.002") //0.2 %
+	for _, tc := range []struct {
+		name   string
+		entry  string
+		myBuy  string
+		spread string
+		from   string
+		want   string
+	}{
+		//the entry cost has the buy fee in already, only the sell fee is added
+		{"entry cost", "100.2", "", "0", "entry cost", "100.40"},
+		{"entry cost and spread", "100.2", "99", "1", "entry cost", "101.40"},
+		//a buy price pays both fees
+		{"my buy", "", "100", "0", "my buy", "100.40"},
+		{"my buy and spread", "", "100", "1", "my buy", "101.40"},
+	} {
+		o := &CompeteTrade{MinNetSpreadPercent: decimal.RequireFromString(tc.spread)}
+		if tc.entry != "" {
+			o.EntryPrice = decimal.RequireFromString(tc.entry)
+		}
+		m := &market.MarketPair{}
+		if tc.myBuy != "" {
+			m.MyHighestBuy.Price = decimal.RequireFromString(tc.myBuy)
+		}
+		ref, from := o.entryRef(m, fee)
+		if from != tc.from {
+			t.Errorf("%s: reference from %s, want %s", tc.name, from, tc.from)
+		}
+		if got := o.minSellPrice(ref, fee).StringFixed(2); got != tc.want {
+			t.Errorf("%s: min sell %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestReleaseOnlyTheConfirmedProposal(t *testing.T) {
+	q := log.New(ioutil.Discard, "", 0)
+	o := &CompeteTrade{Pair: "BTC-USDT", infoLog: q, warnLog: q, errLog: q}
+	if err := o.SetOperation("controlled"); err != nil {
+		t.Fatal(err)
+	}
+	r := market.Order{MarketID: "BTC-USDT", Side: "buy", Type: "limit", TimeInForce: "gtc", LimitPrice: "100", Quantity: "1"}
+	moved := r
+	moved.LimitPrice = "100.1"
+
+	if o.release("buy", r) {
+		t.Fatal("unconfirmed order released")
+	}
+	o.confirmed = true
+	if o.release("buy", moved) {
+		t.Fatal("order changed since its proposal released on confirm")
+	}
+	if o.proposed["buy"] != moved {
+		t.Fatalf("proposal %+v, want the changed order", o.proposed["buy"])
+	}
+	if o.release("sell", market.Order{Side: "sell", LimitPrice: "110", Quantity: "1"}) {
+		t.Fatal("order without a proposal released on confirm")
+	}
+	if !o.release("buy", moved) {
+		t.Fatal("confirmed proposal not released")
+	}
+	if _, found := o.proposed["buy"]; found {
+		t.Error("released proposal kept")
+	}
+
+	o.confirmed = false
+	if err := o.SetOperation("auto"); err != nil {
+		t.Fatal(err)
+	}
+	if !o.release("buy", r) {
+		t.Error("order held in auto operation")
+	}
+}
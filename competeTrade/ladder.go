@@ -0,0 +1,227 @@
+package competeTrade
+
+import (
+	"arbiter/market"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// DefaultLadderDrift is the default of LadderDriftPercent.
+const DefaultLadderDrift = 0.2
+
+// LadderLevel is one level of a quote ladder: Offset is its distance from the top
+// quote in percent, away from the book's middle, and Weight its share of the side
+// quantity relative to the other levels.
+type LadderLevel struct {
+	Offset decimal.Decimal `json:"offset"`
+	Weight decimal.Decimal `json:"weight"`
+}
+
+// ladderOrder is an own open order of one side.
+type ladderOrder struct {
+	id    string
+	price decimal.Decimal
+	open  decimal.Decimal
+}
+
+// ladderSide keeps one order per ladder level on side. The top level competes like
+// the single order does: one increment better than the best rival price, within the
+// Buy/Sell limits and the net spread. An order is kept while it is within
+// LadderDriftPercent of its level's price (the top level only at its exact price);
+// the other orders of the side are cancelled one by one and missing levels are put.
+// It is true if an order was placed or cancelled.
+func (o *CompeteTrade) ladderSide(m *market.MarketPair, side string) bool {
+	if side == "buy" && o.Buy.IsZero() || side == "sell" && o.Sell.IsZero() {
+		return false
+	}
+	var orders []ladderOrder
+	for _, d := range m.MyOrders {
+		if d.Side != side || d.MarketID != o.Pair {
+			continue
+		}
+		p, _ := decimal.NewFromString(d.LimitPrice)
+		q, _ := decimal.NewFromString(d.OpenQuantity)
+		orders = append(orders, ladderOrder{id: d.ID, price: p, open: q})
+	}
+	top, found := o.ladderTop(m, side)
+	if !found {
+		return false
+	}
+
+	inc := m.GetIncrement()
+	drift := o.LadderDriftPercent.Div(hundred)
+	kept := make([]bool, len(orders))
+	have := make([]bool, len(o.Ladder))
+	prices := make([]decimal.Decimal, len(o.Ladder))
+	weights := decimal.Zero
+	for i, l := range o.Ladder {
+		weights = weights.Add(l.Weight)
+		if side == "buy" {
+			prices[i] = top.Mul(one.Sub(l.Offset.Div(hundred))).Div(inc).Floor().Mul(inc)
+		} else {
+			prices[i] = top.Mul(one.Add(l.Offset.Div(hundred))).Div(inc).Ceil().Mul(inc)
+		}
+		tolerance := prices[i].Mul(drift)
+		if i == 0 {
+			tolerance = decimal.Zero
+		}
+		best := -1
+		for j, d := range orders {
+			off := d.price.Sub(prices[i]).Abs()
+			if !kept[j] && off.LessThanOrEqual(tolerance) && (best < 0 || off.LessThan(orders[best].price.Sub(prices[i]).Abs())) {
+				best = j
+			}
+		}
+		if best >= 0 {
+			kept[best], have[i] = true, true
+		}
+	}
+
+	acted := false
+	var stale []ladderOrder
+	for j, d := range orders {
+		if !kept[j] {
+			stale = append(stale, d)
+		}
+	}
+	if o.cancelLadder(m, side, stale) {
+		acted = true
+	}
+	if !weights.IsPositive() {
+		o.errLog.Println(o.Pair, "ladder without weights")
+		return acted
+	}
+
+	//the side quantity at the top price, limited like the single order by the balances
+	bl, av := m.GetBalanceAndAvail()
+	var total, budget decimal.Decimal
+	if !o.Quantity.IsZero() {
+		total = o.Quantity
+	} else {
+		total = o.USDQuantity.DivRound(top, int32(m.Spec.QuantityPrecision))
+	}
+	keptOpen := decimal.Zero
+	for j, d := range orders {
+		if kept[j] {
+			keptOpen = keptOpen.Add(d.open)
+		}
+	}
+	if side == "buy" {
+		budget = o.MaxBalance.Sub(bl).Sub(keptOpen)
+	} else {
+		budget = decimal.Min(av, bl.Sub(o.MinBalance).Sub(keptOpen)) //open sells are already out of av
+	}
+
+	minQ, _ := decimal.NewFromString(m.Spec.MinQuantity)
+	minC, _ := decimal.NewFromString(m.Spec.MinCost)
+	for i, l := range o.Ladder {
+		if have[i] {
+			continue
+		}
+		q := decimal.Min(total.Mul(l.Weight).Div(weights), budget).Truncate(int32(m.Spec.QuantityPrecision))
+		if q.LessThan(minQ) || q.Mul(prices[i]).LessThan(minC) {
+			o.infoLog.Println(o.Pair, "no suitable", side, "quantity for ladder level", i, q)
+			continue
+		}
+		if !o.cageAllows(side, prices[i]) {
+			continue
+		}
+		r := market.Order{
+			LimitPrice:  prices[i].String(),
+			MarketID:    o.Pair,
+			Quantity:    q.String(),
+			Side:        side,
+			TimeInForce: "gtc", //good till cancel
+			Type:        "limit",
+		}
+		if !o.release(fmt.Sprint(side, i), r) {
+			continue
+		}
+		c, err := m.NewOrder(r)
+		if err != nil {
+			o.orderFailed("put ladder "+side, err)
+			return true
+		}
+		acted = true
+		budget = budget.Sub(q)
+		if side == "buy" {
+			o.lastBuy = c
+		} else {
+			o.lastSell = c
+		}
+		if i == 0 { //the cage follows the top level, a further one would pull it away from the book
+			if side == "buy" {
+				o.cage.placedBuy(prices[i], o.CageWindow, o.CagePercent)
+			} else {
+				o.cage.placedSell(prices[i], o.CageWindow, o.CagePercent)
+			}
+		}
+		o.infoLog.Println(o.Pair, "put ladder", side, "level", i, "at:", r.LimitPrice, "id:", c.ID, "open:", c.OpenQuantity)
+	}
+	return acted
+}
+
+// ladderTop is the price of the top level of side; false without rivals or when
+// beating the rival would pass the Buy/Sell limit, as in the single order mode. The
+// side is then left as it is.
+func (o *CompeteTrade) ladderTop(m *market.MarketPair, side string) (decimal.Decimal, bool) {
+	rival, found := rivalBest(m, side)
+	if !found {
+		o.infoLog.Println(o.Pair, "no rival", side, "to ladder from")
+		return decimal.Zero, false
+	}
+	fee := o.feeRate(m)
+	inc := m.GetIncrement()
+	if side == "buy" {
+		top := rival.Add(inc)
+		if top.GreaterThan(o.Buy) {
+			o.infoLog.Println(o.Pair, "ladder buy at", top, "above the buy limit", o.Buy)
+			return decimal.Zero, false
+		}
+		ref := m.MyLowestSell.Price
+		if ref.IsZero() {
+			ref = m.MarketLowestSell.Price.Sub(inc)
+		}
+		return decimal.Min(top, o.maxBuyPrice(ref, fee), m.MarketLowestSell.Price.Sub(inc)), true
+	}
+	top := rival.Sub(inc)
+	if top.LessThan(o.Sell) {
+		o.infoLog.Println(o.Pair, "ladder sell at", top, "below the sell limit", o.Sell)
+		return decimal.Zero, false
+	}
+	top = decimal.Max(top, m.MarketHighestBuy.Price.Add(inc))
+	if o.Operation != opAutoSell {
+		ref, _ := o.entryRef(m, fee)
+		top = decimal.Max(top, o.minSellPrice(ref, fee))
+	}
+	return top, true
+}
+
+// rivalBest is the best price of side with quantity beyond our own orders.
+func rivalBest(m *market.MarketPair, side string) (decimal.Decimal, bool) {
+	own := make(map[string]decimal.Decimal)
+	for _, d := range m.MyOrders {
+		if d.Side == side {
+			p, _ := decimal.NewFromString(d.LimitPrice)
+			q, _ := decimal.NewFromString(d.OpenQuantity)
+			own[p.String()] = own[p.String()].Add(q)
+		}
+	}
+	for _, l := range m.Book.Top(side, market.SnapshotDepth) {
+		if l.Quantity.GreaterThan(own[l.Price.String()]) {
+			return l.Price, true
+		}
+	}
+	return decimal.Zero, false
+}
+
+func (o *CompeteTrade) cancelLadder(m *market.MarketPair, side string, orders []ladderOrder) bool {
+	for _, d := range orders {
+		o.infoLog.Println(o.Pair, "ladder", side, "at", d.price, "drifted, canceling")
+		if err := m.CancelOrder(d.id); err != nil {
+			o.orderFailed("cancel ladder "+side, err)
+		}
+	}
+	return len(orders) > 0
+}
@@ -0,0 +1,95 @@
+package competeTrade
+
+import (
+	"arbiter/market"
+	"arbiter/market/fakeprobit"
+	"io/ioutil"
+	"log"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// Every ladder level is checked against the cage, not only the top one: after the
+// bids jump the top buy is refused, and so must be the levels under it.
+func TestLadderLevelsInCage(t *testing.T) {
+	d := decimal.RequireFromString
+	f, c := fakeprobit.NewTestComms(t, func(f *fakeprobit.Server) error {
+		f.AddMarket(market.PairSpec{ID: "BTC-USDT", BaseCurrencyID: "BTC", QuoteCurrencyID: "USDT", PriceIncrement: "0.1",
+			MinQuantity: "0.0001", MinCost: "1", QuantityPrecision: 4, MakerFeeRate: "0"})
+		f.SetBalance("USDT", d("1000"))
+		f.PlaceExternal("BTC-USDT", "buy", d("100"), d("2"))
+		return f.PlaceExternal("BTC-USDT", "sell", d("110"), d("2"))
+	})
+	m := fakeprobit.NewTestPair(t, c, "BTC-USDT", market.BaseStrategy{})
+	if err := c.RegisterPair("BTC-USDT", m); err != nil {
+		t.Fatal(err)
+	}
+	q := log.New(ioutil.Discard, "", 0)
+
+	o := NewCompeteTrade("BTC-USDT", d("200"), d("50"), decimal.Zero, d("40"), decimal.Zero, decimal.Zero,
+		d("1000"), decimal.Zero, d("100"), q, q, q)
+	o.Ladder = []LadderLevel{{Offset: d("0"), Weight: d("1")}, {Offset: d("1"), Weight: d("1")}}
+	var trips []CageTrip
+	o.OnCageTrip = func(t CageTrip) { trips = append(trips, t) }
+	pass := func() {
+		m.Do(func(m *market.MarketPair) {
+			m.UpdateMarketHttp()
+			o.ladderSide(m, "buy")
+			m.UpdateMyOrders()
+		})
+	}
+
+	pass()
+	if n := len(m.Snapshot().MyOrders); n != 2 {
+		t.Fatalf("%d ladder orders placed, want 2", n)
+	}
+	limit := o.cage.cagePriceBuy //the top buy at 100.1 plus CagePercent
+
+	f.PlaceExternal("BTC-USDT", "buy", d("105"), d("1"))
+	pass()
+	for _, b := range m.Snapshot().MyOrders {
+		if d(b.LimitPrice).GreaterThan(limit) {
+			t.Errorf("buy at %s placed above the cage limit %s", b.LimitPrice, limit)
+		}
+	}
+	if len(trips) != 1 {
+		t.Errorf("%d cage trips reported, want 1", len(trips))
+	}
+}
+
+// A rival beyond the Buy/Sell limit leaves the side alone, as in the single order mode.
+func TestLadderTopLimits(t *testing.T) {
+	d := decimal.RequireFromString
+	_, c := fakeprobit.NewTestComms(t, func(f *fakeprobit.Server) error {
+		f.AddMarket(market.PairSpec{ID: "BTC-USDT", BaseCurrencyID: "BTC", QuoteCurrencyID: "USDT", PriceIncrement: "0.1",
+			MinQuantity: "0.0001", MinCost: "1", QuantityPrecision: 4, MakerFeeRate: "0"})
+		f.PlaceExternal("BTC-USDT", "buy", d("100"), d("2"))
+		return f.PlaceExternal("BTC-USDT", "sell", d("110"), d("2"))
+	})
+	m := fakeprobit.NewTestPair(t, c, "BTC-USDT", market.BaseStrategy{})
+	q := log.New(ioutil.Discard, "", 0)
+	for _, tc := range []struct {
+		side, buy, sell string
+		found           bool
+		top             string
+	}{
+		{"buy", "105", "0", true, "100.1"},
+		{"buy", "100", "0", false, ""},
+		{"sell", "0", "105", true, "109.9"},
+		{"sell", "0", "110", false, ""},
+	} {
+		o := NewCompeteTrade("BTC-USDT", d(tc.buy), d(tc.sell), decimal.Zero, d("40"), decimal.Zero, decimal.Zero,
+			d("1000"), decimal.Zero, d("100"), q, q, q)
+		o.Operation = opAutoSell //no entry cost, only the limits
+		var top decimal.Decimal
+		var found bool
+		m.Do(func(m *market.MarketPair) {
+			m.UpdateMarketHttp()
+			top, found = o.ladderTop(m, tc.side)
+		})
+		if found != tc.found || found && !top.Equal(d(tc.top)) {
+			t.Errorf("%s top with limits %s/%s: %v %s, want %v %s", tc.side, tc.buy, tc.sell, found, top, tc.found, tc.top)
+		}
+	}
+}
@@ -3,7 +3,10 @@ module arbiter
 go 1.16
 
 require (
-	github.com/sacOO7/gowebsocket v0.0.0-20210515122958-9396f1a71e23 // indirect
-	github.com/shopspring/decimal v1.2.0 // indirect
-	go.uber.org/multierr v1.7.0 // indirect
+	github.com/gorilla/websocket v1.4.2
+	github.com/sacOO7/gowebsocket v0.0.0-20210515122958-9396f1a71e23
+	github.com/shopspring/decimal v1.2.0
+	github.com/spf13/cobra v1.1.3
+	go.uber.org/multierr v1.7.0
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
 )
@@ -1,18 +1,61 @@
 package main
 
 import (
+	"arbiter/cmd"
 	"arbiter/market"
+	"arbiter/market/fakeprobit"
 	"bufio"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"strings"
 	"time"
+
+	"github.com/shopspring/decimal"
 )
 
 func main() {
+	//a command, like multiCompete, instead of the console
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		cmd.Execute()
+		return
+	}
 	fmt.Println("main")
+	credSource := flag.String("cred", "file", "credential source: file/env/keystore")
+	account := flag.String("account", "", "account name in the keystore or env (PROBIT_<ACCOUNT>_ID)")
+	idFile := flag.String("idfile", "probID.txt", "client id file for -cred file")
+	secretFile := flag.String("secretfile", "probSecret.txt", "client secret file for -cred file")
+	keystore := flag.String("keystore", "probKeystore.json", "keystore file for -cred keystore")
+	seal := flag.Bool("seal", false, "store -idfile/-secretfile as -account in -keystore and exit")
+	protocol := flag.String("protocol", "socket", "market data source: socket/http")
+	fake := flag.Bool("fake", false, "trade on an in-process fake exchange with BTC-USDT and ETH-USDT instead of ProBit")
+	flag.Parse()
+
+	if *seal {
+		if err := sealKeystore(*keystore, *account, *idFile, *secretFile); err != nil {
+			fmt.Println("error sealing keystore:", err)
+			return
+		}
+		fmt.Println("keystore written:", *keystore)
+		return
+	}
+	opts := market.DefaultCommsOptions()
+	if *fake {
+		f := fakeprobit.New()
+		defer f.Close()
+		f.AddDemoMarket("BTC-USDT", decimal.NewFromInt(30000))
+		f.AddDemoMarket("ETH-USDT", decimal.NewFromInt(2000))
+		opts = f.CommsOptions()
+	} else {
+		creds, err := cmd.CredentialProvider(*credSource, *account, *idFile, *secretFile, *keystore)
+		if err != nil {
+			fmt.Println("error:", err)
+			return
+		}
+		opts.Credentials = creds
+	}
 
 	all, err := os.OpenFile("./multilogs/all.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
 	if err != nil {
@@ -38,7 +81,7 @@ func main() {
 	clogInfo := log.New(io.MultiWriter(clog, all), "       ", log.Ldate|log.Ltime|log.Lshortfile)
 	clogWarn := log.New(io.MultiWriter(os.Stdout, clog, all), "       ", log.Ldate|log.Ltime|log.Lshortfile)
 	clogError := log.New(io.MultiWriter(os.Stdout, clog, all), "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
-	c := market.NewComms(clogInfo, clogWarn, clogError)
+	c := market.NewComms(opts, clogInfo, clogWarn, clogError)
 	if c == nil {
 		errLog.Println("error creating comms")
 		return
@@ -48,30 +91,26 @@ func main() {
 		errLog.Println("CRIT: error in fetching market specs: ", err)
 	}
 
-	/////////////////market pair
-	pair := "BTC-USDT"
-	plog, err := os.OpenFile("./multilogs/"+pair+".txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
-	if err != nil {
-		errLog.Println(err)
-		return
+	c.StartAuth()
+	var poller *market.Poller
+	if *protocol == "http" {
+		poller = market.NewPoller(c, clogInfo, clogWarn, clogError)
+		poller.Start()
+	} else {
+		c.OpenSocket()
 	}
-	plogInfo := log.New(io.MultiWriter(plog, all), "       ", log.Ldate|log.Ltime|log.Lshortfile)
-	plogWarn := log.New(io.MultiWriter(os.Stdout, plog, all), "       ", log.Ldate|log.Ltime|log.Lshortfile)
-	plogError := log.New(io.MultiWriter(os.Stdout, plog, all), "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
 
-	sp, err := c.GetMarketSpec(pair)
+	/////////////////market pairs, more can be added from the console
+	pairs := make(map[string]*market.MarketPair)
+	m, err := newPair(c, "BTC-USDT", all)
 	if err != nil {
-		errLog.Println("CRIT: error in fetching pair spec: ", pair, err)
+		errLog.Println("CRIT: error in adding pair: ", err)
 		return
 	}
-	m := market.NewMarketPair(pair, c, sp, callBack, plogInfo, plogWarn, plogError)
-
-	time.Sleep(time.Microsecond * 10)
-
-	c.StartAuth()
-	c.OpenSocket()
-	c.RegisterPair(pair, &m)
-	c.Subscribe(pair)
+	pairs["BTC-USDT"] = m
+	if poller != nil {
+		poller.Add(m, 0)
+	}
 	for {
 		select {
 		case t := <-ch:
@@ -83,18 +122,87 @@ func main() {
 				warnLog.Println("exit by command")
 				return
 			}
+			if split[0] == "a" && len(split) > 1 {
+				if _, found := pairs[split[1]]; found {
+					warnLog.Println("pair already added:", split[1])
+					continue
+				}
+				m, err := newPair(c, split[1], all)
+				if err != nil {
+					errLog.Println("error in adding pair: ", split[1], err)
+					continue
+				}
+				pairs[split[1]] = m
+				if poller != nil {
+					poller.Add(m, 0)
+				}
+				warnLog.Println("pair added:", split[1])
+			}
+			if split[0] == "d" && len(split) > 1 {
+				c.UnregisterPair(split[1])
+				if poller != nil {
+					poller.Remove(split[1])
+				}
+				if m, found := pairs[split[1]]; found {
+					m.Close()
+				}
+				delete(pairs, split[1])
+				warnLog.Println("pair dropped:", split[1])
+			}
 		default:
 		}
 
 	}
 }
+
+// newPair opens the pair's log file, makes its MarketPair and subscribes it.
+func newPair(c *market.Comms, pair string, all io.Writer) (*market.MarketPair, error) {
+	plog, err := os.OpenFile("./multilogs/"+pair+".txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	plogInfo := log.New(io.MultiWriter(plog, all), "       ", log.Ldate|log.Ltime|log.Lshortfile)
+	plogWarn := log.New(io.MultiWriter(os.Stdout, plog, all), "       ", log.Ldate|log.Ltime|log.Lshortfile)
+	plogError := log.New(io.MultiWriter(os.Stdout, plog, all), "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
+
+	sp, err := c.GetMarketSpec(pair)
+	if err != nil {
+		return nil, err
+	}
+	m := market.NewMarketPair(pair, c, sp, market.FuncStrategy(callBack), plogInfo, plogWarn, plogError)
+	if err = c.AddPair(pair, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
 func callBack(m *market.MarketPair) {
 	fmt.Println("callbacked", m.MarketHighestBuy)
 }
+func sealKeystore(keystore string, account string, idFile string, secretFile string) error {
+	id, secret, err := market.FileCredentials{IDFile: idFile, SecretFile: secretFile}.Credentials()
+	if err != nil {
+		return err
+	}
+	if account == "" {
+		account = "default"
+	}
+	pass := cmd.ReadPassphrase()
+	accounts, err := market.ReadKeystore(keystore, pass)
+	if os.IsNotExist(err) {
+		accounts, err = map[string]market.Credentials{}, nil
+	}
+	if err != nil {
+		return err
+	}
+	accounts[account] = market.Credentials{ID: id, Secret: secret}
+	return market.WriteKeystore(keystore, pass, accounts)
+}
+
 func printHelp() {
 
 	fmt.Println("--command: h for report")
 	fmt.Println("command: x for exit")
+	fmt.Println("command: a PAIR to add a pair, d PAIR to drop it")
 	fmt.Println("command: ? for this help")
 }
 func ui(ch chan<- string) {
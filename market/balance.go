@@ -0,0 +1,194 @@
+package market
+
+import (
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// BalanceBook is the account balance of every currency, shared by all pairs of a Comms.
+// It is loaded from /balance, kept current from own order placements, cancellations
+// and fills, and reconciled with /balance periodically. While the private balance
+// channel is live its packets are authoritative and the local estimates are skipped.
+type BalanceBook struct {
+	mu       sync.RWMutex
+	coins    map[string]CoinBalance
+	loaded   time.Time
+	streamed bool
+	stale    bool //fills were seen without their amounts, see invalidate
+	notify   func(changed map[string]CoinBalance) //called outside the lock
+}
+
+type CoinBalance struct {
+	Total decimal.Decimal
+	Avail decimal.Decimal
+}
+
+func newBalanceBook() *BalanceBook {
+	return &BalanceBook{coins: make(map[string]CoinBalance)}
+}
+
+// Get returns the balance of a currency; found is false before the first load
+// and while the book is stale.
+func (o *BalanceBook) Get(currency string) (CoinBalance, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if o.loaded.IsZero() || o.stale {
+		return CoinBalance{}, false
+	}
+	return o.coins[currency], true
+}
+
+// All is a copy of every currency's balance.
+func (o *BalanceBook) All() map[string]CoinBalance {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	m := make(map[string]CoinBalance, len(o.coins))
+	for c, b := range o.coins {
+		m[c] = b
+	}
+	return m
+}
+
+func (o *BalanceBook) Loaded() time.Time {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.loaded
+}
+
+// load replaces the whole book, from /balance or a balance channel snapshot.
+func (o *BalanceBook) load(coins map[string]CoinBalance) {
+	o.mu.Lock()
+	changed := make(map[string]CoinBalance)
+	for c, b := range coins {
+		if old, found := o.coins[c]; !found || !old.equal(b) {
+			changed[c] = b
+		}
+	}
+	for c := range o.coins {
+		if _, found := coins[c]; !found {
+			changed[c] = CoinBalance{Total: decimal.Zero, Avail: decimal.Zero}
+		}
+	}
+	o.coins = coins
+	o.loaded = time.Now()
+	o.stale = false
+	o.mu.Unlock()
+	o.changed(changed)
+}
+
+func (o CoinBalance) equal(b CoinBalance) bool {
+	return o.Total.Equal(b.Total) && o.Avail.Equal(b.Avail)
+}
+
+func (o *BalanceBook) changed(m map[string]CoinBalance) {
+	if o.notify != nil && len(m) > 0 {
+		o.notify(m)
+	}
+}
+
+// set stores a balance channel update.
+func (o *BalanceBook) set(currency string, b CoinBalance) {
+	o.mu.Lock()
+	old, found := o.coins[currency]
+	o.coins[currency] = b
+	o.mu.Unlock()
+	if !found || !old.equal(b) {
+		o.changed(map[string]CoinBalance{currency: b})
+	}
+}
+
+func (o *BalanceBook) setStreamed(s bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.streamed = s
+}
+
+// invalidate marks the book stale, so the next read loads /balance again, unless
+// the balance channel is live. It is used for fills seen over HTTP, which only
+// report the order's filled quantity, not the amounts moved.
+func (o *BalanceBook) invalidate() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.streamed {
+		o.stale = true
+	}
+}
+
+// adjust adds to the total and available amount of a currency, unless the balance
+// channel is live and will report the change itself.
+func (o *BalanceBook) adjust(currency string, total decimal.Decimal, avail decimal.Decimal) {
+	o.mu.Lock()
+	if o.streamed || o.loaded.IsZero() || (total.IsZero() && avail.IsZero()) {
+		o.mu.Unlock()
+		return
+	}
+	b := o.coins[currency]
+	b.Total = b.Total.Add(total)
+	b.Avail = b.Avail.Add(avail)
+	o.coins[currency] = b
+	o.mu.Unlock()
+	o.changed(map[string]CoinBalance{currency: b})
+}
+
+// reserve holds the funds of a newly placed open order.
+func (o *BalanceBook) reserve(s PairSpec, c CurrentOrder) {
+	p, _ := decimal.NewFromString(c.LimitPrice)
+	q, _ := decimal.NewFromString(c.OpenQuantity)
+	if c.Side == "buy" {
+		o.adjust(s.QuoteCurrencyID, decimal.Zero, p.Mul(q).Neg())
+	} else {
+		o.adjust(s.BaseCurrencyID, decimal.Zero, q.Neg())
+	}
+}
+
+// release returns the funds of the cancelled part of an order.
+func (o *BalanceBook) release(s PairSpec, c CurrentOrder) {
+	p, _ := decimal.NewFromString(c.LimitPrice)
+	q, _ := decimal.NewFromString(c.CancelledQuantity)
+	if c.Side == "buy" {
+		o.adjust(s.QuoteCurrencyID, decimal.Zero, p.Mul(q))
+	} else {
+		o.adjust(s.BaseCurrencyID, decimal.Zero, q)
+	}
+}
+
+// fill moves a trade of an own order between the base and quote currency.
+// The spent side was already taken from avail when the order was reserved.
+func (o *BalanceBook) fill(s PairSpec, t MyTrade) {
+	q, _ := decimal.NewFromString(t.Quantity)
+	cost, _ := decimal.NewFromString(t.Cost)
+	if t.Side == "buy" {
+		o.adjust(s.BaseCurrencyID, q, q)
+		o.adjust(s.QuoteCurrencyID, cost.Neg(), decimal.Zero)
+	} else {
+		o.adjust(s.BaseCurrencyID, q.Neg(), decimal.Zero)
+		o.adjust(s.QuoteCurrencyID, cost, cost)
+	}
+	if fee, err := decimal.NewFromString(t.FeeAmount); err == nil && !fee.IsZero() {
+		o.adjust(t.FeeCurrencyID, fee.Neg(), fee.Neg())
+	}
+}
+
+// Balances is the account balance book; it is loaded on first use.
+func (o *Comms) Balances() *BalanceBook {
+	return o.balances
+}
+
+// pushBalances passes balance changes to every pair trading one of the currencies.
+func (o *Comms) pushBalances(changed map[string]CoinBalance) {
+	for _, m := range o.pairs() {
+		m.PushBalances(changed)
+	}
+}
+
+// keepBalances reconciles the balance book with /balance every BalanceReconcile.
+func (o *Comms) keepBalances() {
+	for {
+		if err := o.FetchBalances(); err != nil {
+			o.errLog.Println("balance reconcile failed:", err)
+		}
+		time.Sleep(o.opts.BalanceReconcile)
+	}
+}
@@ -0,0 +1,90 @@
+package market
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+//Consistency of the websocket order book. A book is untrusted after a diff arrives
+//before any snapshot, when it is crossed (best bid >= best ask), when the server
+//reports a non-ok status or a lag above MaxLag. Strategy callbacks are held back
+//until a consistent packet arrives again, and the book is resynced: resubscribed,
+//then loaded over HTTP if it is still untrusted ResyncTimeout later.
+
+const (
+	DefaultMaxLag        = 3000 //ms, as reported in MarketData.Lag
+	DefaultResyncTimeout = 5 * time.Second
+)
+
+var ErrBookInconsistent = errors.New("order book is inconsistent")
+
+// BookTrusted is false while the book is being resynced or is stale.
+func (o *MarketPair) BookTrusted() bool {
+	return o.bookTrusted
+}
+
+// bookProblem returns why the book can't be used, "" if it can.
+func (o *MarketPair) bookProblem(d MarketData) string {
+	if d.Status != "" && d.Status != "ok" {
+		return "status " + d.Status
+	}
+	if d.Lag > o.MaxLag {
+		return "stale, lag " + strconv.Itoa(d.Lag) + "ms"
+	}
+	if o.bookCrossed() {
+		return "crossed book " + o.MarketHighestBuy.Price.String() + " >= " + o.MarketLowestSell.Price.String()
+	}
+	return ""
+}
+
+func (o *MarketPair) bookCrossed() bool {
+	b, okb := o.Book.Best("buy")
+	s, oks := o.Book.Best("sell")
+	return okb && oks && b.Price.GreaterThanOrEqual(s.Price)
+}
+
+// resync asks for a fresh snapshot by subscribing again; the server answers with a
+// reset packet. A timer posts resyncExpired to the loop, so the HTTP fallback runs
+// even if no packet arrives in the meantime. A resync in progress is left running.
+func (o *MarketPair) resync(reason string) {
+	o.bookTrusted = false
+	if o.resyncing {
+		return
+	}
+	o.warnLog.Println(o.pair, "resyncing order book:", reason)
+	o.resyncing = true
+	o.resyncSeq++
+	seq := o.resyncSeq
+	time.AfterFunc(o.ResyncTimeout, func() { o.post(func() { o.resyncExpired(seq) }) })
+	o.comms.Subscribe(o.pair)
+}
+
+// resyncExpired loads the HTTP order book if resync seq is still in progress.
+// If that fails too, the book is resynced again.
+func (o *MarketPair) resyncExpired(seq int) {
+	if !o.resyncing || seq != o.resyncSeq {
+		return
+	}
+	o.warnLog.Println(o.pair, "book untrusted", o.ResyncTimeout, "after resubscribe, loading the HTTP order book")
+	o.resyncing = false
+	r, err := o.comms.GetMarketOrdersHttp(o.pair)
+	if err == nil {
+		err = o.Book.Load(r.Data)
+	}
+	if err != nil {
+		o.errLog.Println(o.pair, "HTTP resync failed:", err)
+		o.resync("HTTP resync failed")
+		return
+	}
+	o.findMarketEdges()
+	o.haveSnapshot = true
+	o.haveBook = true
+	if o.bookCrossed() {
+		o.resync("crossed HTTP order book")
+		return
+	}
+	o.warnLog.Println(o.pair, "order book trusted again")
+	o.bookTrusted = true
+	o.strategy.OnBookUpdate(o)
+}
@@ -0,0 +1,70 @@
+package market_test
+
+import (
+	"arbiter/market"
+	"arbiter/market/fakeprobit"
+	"testing"
+	"time"
+)
+
+// A book that turns untrusted is loaded over HTTP after ResyncTimeout, even if no
+// further marketdata packet arrives to notice the timeout.
+func TestResyncFallsBackToHTTP(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		d    market.MarketData
+	}{
+		{"stale", market.MarketData{Lag: 10000, Reset: true, OrderBooks: []market.MarketOrder{
+			{Side: "buy", Price: "99", Quantity: "1"}, {Side: "sell", Price: "101", Quantity: "1"}}}},
+		{"crossed", market.MarketData{Reset: true, OrderBooks: []market.MarketOrder{
+			{Side: "buy", Price: "102", Quantity: "1"}, {Side: "sell", Price: "101", Quantity: "1"}}}},
+		{"diff before snapshot", market.MarketData{OrderBooks: []market.MarketOrder{
+			{Side: "buy", Price: "99", Quantity: "1"}}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, c := newFake(t)
+			r := newRecorder()
+			m := fakeprobit.NewTestPair(t, c, testPair, r)
+			m.Do(func(m *market.MarketPair) { m.ResyncTimeout = 100 * time.Millisecond })
+			m.UpdateMarketData(tc.d)
+			m.Do(func(m *market.MarketPair) {
+				if m.BookTrusted() {
+					t.Error("bad packet trusted")
+				}
+			})
+			select {
+			case <-r.books:
+			case <-time.After(waitTimeout):
+				t.Fatal("no book update after the resync timeout")
+			}
+			m.Do(func(m *market.MarketPair) {
+				if !m.BookTrusted() || m.MarketHighestBuy.Price.String() != "99.9" || m.MarketLowestSell.Price.String() != "100.1" {
+					t.Errorf("book after HTTP resync: trusted %v %v-%v, want the fake's 99.9-100.1",
+						m.BookTrusted(), m.MarketHighestBuy.Price, m.MarketLowestSell.Price)
+				}
+			})
+		})
+	}
+}
+
+// A diff uncrossing a crossed book doesn't make it trusted, only a new snapshot does.
+func TestCrossedBookWaitsForSnapshot(t *testing.T) {
+	_, c := newFake(t)
+	m := fakeprobit.NewTestPair(t, c, testPair, market.BaseStrategy{})
+	m.Do(func(m *market.MarketPair) { m.ResyncTimeout = time.Hour })
+	m.UpdateMarketData(market.MarketData{Reset: true, OrderBooks: []market.MarketOrder{
+		{Side: "buy", Price: "102", Quantity: "1"}, {Side: "buy", Price: "99", Quantity: "1"}, {Side: "sell", Price: "101", Quantity: "1"}}})
+	m.UpdateMarketData(market.MarketData{OrderBooks: []market.MarketOrder{{Side: "buy", Price: "102", Quantity: "0"}}})
+	m.Do(func(m *market.MarketPair) {
+		if m.BookTrusted() {
+			t.Error("diff on a crossed book trusted")
+		}
+	})
+	m.UpdateMarketData(market.MarketData{Reset: true, OrderBooks: []market.MarketOrder{
+		{Side: "buy", Price: "99", Quantity: "1"}, {Side: "sell", Price: "101", Quantity: "1"}}})
+	m.Do(func(m *market.MarketPair) {
+		if !m.BookTrusted() {
+			t.Error("new snapshot not trusted")
+		}
+	})
+}
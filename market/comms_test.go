@@ -0,0 +1,587 @@
+package market_test
+
+import (
+	"arbiter/market"
+	"arbiter/market/fakeprobit"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	testPair    = "BTC-USDT"
+	waitTimeout = 5 * time.Second
+)
+
+// newFake starts a fake exchange with testPair around 100 (bids 99.9-99.5, asks
+// 100.1-100.5, 10 each) and an authorized Comms on it.
+func newFake(t *testing.T) (*fakeprobit.Server, *market.Comms) {
+	t.Helper()
+	return fakeprobit.NewTestComms(t, func(f *fakeprobit.Server) error {
+		return f.AddDemoMarket(testPair, decimal.NewFromInt(100))
+	})
+}
+
+// recorder passes the pair's events to channels; an event is dropped if its channel is full.
+type recorder struct {
+	market.BaseStrategy
+	books    chan struct{}
+	orders   chan []market.CurrentOrder
+	fills    chan []market.MyTrade
+	balances chan string
+}
+
+func newRecorder() *recorder {
+	return &recorder{books: make(chan struct{}, 100), orders: make(chan []market.CurrentOrder, 100),
+		fills: make(chan []market.MyTrade, 100), balances: make(chan string, 100)}
+}
+
+func (o *recorder) OnBookUpdate(m *market.MarketPair) {
+	select {
+	case o.books <- struct{}{}:
+	default:
+	}
+}
+func (o *recorder) OnOwnOrderUpdate(m *market.MarketPair, orders []market.CurrentOrder) {
+	select {
+	case o.orders <- orders:
+	default:
+	}
+}
+func (o *recorder) OnFill(m *market.MarketPair, trades []market.MyTrade) {
+	select {
+	case o.fills <- trades:
+	default:
+	}
+}
+func (o *recorder) OnBalanceChange(m *market.MarketPair, currency string, b market.CoinBalance) {
+	select {
+	case o.balances <- currency:
+	default:
+	}
+}
+
+// eventually fails the test if cond isn't true within waitTimeout.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	end := time.Now().Add(waitTimeout)
+	for !cond() {
+		if time.Now().After(end) {
+			t.Fatal("timed out waiting for", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// openSocket opens the websocket and waits until it is authorized; the returned
+// channel gets every later connection event.
+func openSocket(t *testing.T, c *market.Comms) <-chan market.ConnEvent {
+	t.Helper()
+	events := make(chan market.ConnEvent, 100)
+	c.OnConnState(func(e market.ConnEvent) {
+		select {
+		case events <- e:
+		default:
+		}
+	})
+	c.OpenSocket()
+	t.Cleanup(c.CloseSocket)
+	waitState(t, events, market.ConnAuthorized)
+	return events
+}
+
+func waitState(t *testing.T, events <-chan market.ConnEvent, s market.ConnState) market.ConnEvent {
+	t.Helper()
+	timeout := time.After(waitTimeout)
+	for {
+		select {
+		case e := <-events:
+			if e.State == s {
+				return e
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for socket", s)
+		}
+	}
+}
+
+func limit(side string, price string, quantity string) market.Order {
+	return market.Order{MarketID: testPair, Type: "limit", Side: side, TimeInForce: "gtc", LimitPrice: price, Quantity: quantity}
+}
+
+func dec(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+func TestNewOrderFill(t *testing.T) {
+	f, c := newFake(t)
+	m := fakeprobit.NewTestPair(t, c, testPair, market.BaseStrategy{})
+	if err := c.RegisterPair(testPair, m); err != nil {
+		t.Fatal(err)
+	}
+
+	var o market.CurrentOrder
+	var err error
+	m.Do(func(m *market.MarketPair) { o, err = m.NewOrder(limit("buy", "100", "2")) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Status != "open" || !dec(o.OpenQuantity).Equal(dec("2")) {
+		t.Fatalf("new order %s open %s, want open 2", o.Status, o.OpenQuantity)
+	}
+	if err = f.PlaceExternal(testPair, "sell", dec("100"), dec("0.5")); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Do(func(m *market.MarketPair) { err = m.UpdateMyOrders() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := m.Snapshot()
+	if len(s.MyOrders) != 1 || !dec(s.MyOrders[0].OpenQuantity).Equal(dec("1.5")) {
+		t.Fatalf("orders after a partial fill: %+v, want one open 1.5", s.MyOrders)
+	}
+	if !s.MyHighestBuy.Price.Equal(dec("100")) {
+		t.Errorf("my highest buy %s, want 100", s.MyHighestBuy.Price)
+	}
+	h, err := c.GetTradeHistory(testPair, time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Data) != 1 || h.Data[0].OrderID != o.ID || !dec(h.Data[0].Quantity).Equal(dec("0.5")) {
+		t.Fatalf("trade history %+v, want one fill of 0.5 of order %s", h.Data, o.ID)
+	}
+	if total, _ := f.Balance("BTC"); !total.Equal(dec("1000.5")) {
+		t.Errorf("BTC total %s, want 1000.5", total)
+	}
+}
+
+func TestCancelOrder(t *testing.T) {
+	f, c := newFake(t)
+	m := fakeprobit.NewTestPair(t, c, testPair, market.BaseStrategy{})
+	if err := c.RegisterPair(testPair, m); err != nil {
+		t.Fatal(err)
+	}
+	_, availBefore := f.Balance("USDT")
+
+	var err error
+	m.Do(func(m *market.MarketPair) { _, err = m.NewOrder(limit("buy", "99", "1")) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, avail := f.Balance("USDT"); !avail.Equal(availBefore.Sub(dec("99"))) {
+		t.Fatalf("USDT available %s with the order open, want %s", avail, availBefore.Sub(dec("99")))
+	}
+	m.Do(func(m *market.MarketPair) { err = m.CancelOrders("buy") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	open, err := c.GetMyOrdersPair(testPair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(open) != 0 {
+		t.Fatalf("open orders after cancel: %+v", open)
+	}
+	if _, avail := f.Balance("USDT"); !avail.Equal(availBefore) {
+		t.Errorf("USDT available %s after cancel, want %s", avail, availBefore)
+	}
+	if _, err = c.CancelOrder(market.CancelingOrder{MarketID: testPair, OrderID: "unknown"}); err == nil {
+		t.Error("canceling an unknown order succeeded")
+	}
+}
+
+func TestPrivatePushes(t *testing.T) {
+	f, c := newFake(t)
+	rec := newRecorder()
+	m := fakeprobit.NewTestPair(t, c, testPair, rec)
+	if err := c.AddPair(testPair, m); err != nil {
+		t.Fatal(err)
+	}
+	openSocket(t, c)
+	select {
+	case <-rec.orders: //the open_order snapshot
+	case <-time.After(waitTimeout):
+		t.Fatal("no open_order snapshot")
+	}
+	eventually(t, "a trusted book", func() bool {
+		s := m.Snapshot()
+		return s != nil && s.Trusted && len(s.Bids) == 5 && len(s.Asks) == 5
+	})
+
+	var o market.CurrentOrder
+	var err error
+	m.Do(func(m *market.MarketPair) { o, err = m.NewOrder(limit("buy", "100", "1")) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.PlaceExternal(testPair, "sell", dec("100"), dec("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case trades := <-rec.fills:
+		if len(trades) != 1 || trades[0].OrderID != o.ID || !dec(trades[0].Quantity).Equal(dec("1")) {
+			t.Fatalf("fills %+v, want 1 of order %s", trades, o.ID)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("no fill pushed")
+	}
+	eventually(t, "the filled order to leave MyOrders", func() bool {
+		return len(m.Snapshot().MyOrders) == 0
+	})
+	eventually(t, "the pushed BTC balance", func() bool {
+		b, found := c.Balances().Get("BTC")
+		return found && b.Total.Equal(dec("1001"))
+	})
+	select {
+	case <-rec.balances:
+	case <-time.After(waitTimeout):
+		t.Fatal("no balance change passed to the pair")
+	}
+}
+
+func TestDropConnectionsReconnect(t *testing.T) {
+	f, c := newFake(t)
+	rec := newRecorder()
+	m := fakeprobit.NewTestPair(t, c, testPair, rec)
+	if err := c.AddPair(testPair, m); err != nil {
+		t.Fatal(err)
+	}
+	events := openSocket(t, c)
+	eventually(t, "a trusted book", func() bool {
+		s := m.Snapshot()
+		return s != nil && s.Trusted
+	})
+
+	f.DropConnections()
+	if e := waitState(t, events, market.ConnDisconnected); e.Err == nil {
+		t.Error("disconnect without an error")
+	}
+	if e := waitState(t, events, market.ConnAuthorized); e.Attempt == 0 {
+		t.Error("reconnect reported as the first connection")
+	}
+	//the pair is subscribed again and gets the changes made after the reconnect
+	eventually(t, "the book to be trusted again", func() bool { return m.Snapshot().Trusted })
+	if err := f.PlaceExternal(testPair, "buy", dec("99.95"), dec("3")); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the new bid", func() bool {
+		s := m.Snapshot()
+		return len(s.Bids) > 0 && s.Bids[0].Price.Equal(dec("99.95")) && s.Bids[0].Quantity.Equal(dec("3"))
+	})
+}
+
+// CloseSocket can be called before OpenSocket and more than once.
+func TestCloseSocketTwice(t *testing.T) {
+	_, c := newFake(t)
+	c.CloseSocket()
+	events := openSocket(t, c)
+	c.CloseSocket()
+	waitState(t, events, market.ConnDisconnected)
+	c.CloseSocket()
+	if s := c.ConnState(); s != market.ConnDisconnected {
+		t.Fatal("state after closing:", s)
+	}
+}
+
+// A pair added after the open_order snapshot starts with its resting orders.
+func TestAddPairLoadsOwnOrders(t *testing.T) {
+	_, c := newFake(t)
+	rec := newRecorder()
+	m := fakeprobit.NewTestPair(t, c, testPair, rec)
+	if err := c.AddPair(testPair, m); err != nil {
+		t.Fatal(err)
+	}
+	openSocket(t, c)
+	var err error
+	m.Do(func(m *market.MarketPair) { _, err = m.NewOrder(limit("buy", "99.95", "1")) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the push of the new order goes to this pair, not to the one added again
+	for pushed := false; !pushed; {
+		select {
+		case orders := <-rec.orders:
+			pushed = len(orders) > 0
+		case <-time.After(waitTimeout):
+			t.Fatal("new order not pushed")
+		}
+	}
+	c.UnregisterPair(testPair)
+	m.Close()
+
+	first := make(chan []market.CurrentOrder, 1)
+	m = fakeprobit.NewTestPair(t, c, testPair, market.FuncStrategy(func(m *market.MarketPair) {
+		select {
+		case first <- append([]market.CurrentOrder{}, m.MyOrders...):
+		default:
+		}
+	}))
+	if err = c.AddPair(testPair, m); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case orders := <-first:
+		if len(orders) != 1 || orders[0].LimitPrice != "99.95" {
+			t.Fatalf("own orders at the first book update: %+v, want the buy at 99.95", orders)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("no book update")
+	}
+}
+
+func TestSetFilters(t *testing.T) {
+	_, c := newFake(t)
+	for _, tc := range []struct {
+		filters []string
+		err     error
+	}{
+		{nil, nil},
+		{[]string{market.FilterOrderBooks, market.FilterTicker}, nil},
+		{[]string{market.FilterOrderBooks, market.FilterOrderBooksL1}, nil},
+		{[]string{market.FilterOrderBooksL0}, market.ErrNoBookFilter},
+		{[]string{market.FilterTicker, market.FilterRecentTrades}, market.ErrNoBookFilter},
+		{[]string{market.FilterOrderBooks, "depth"}, market.ErrUnknownFilter},
+	} {
+		if err := c.SetFilters(testPair, tc.filters...); err != tc.err {
+			t.Errorf("SetFilters(%v) = %v, want %v", tc.filters, err, tc.err)
+		}
+	}
+}
+
+// Without the balance channel, fills seen over HTTP make the next read load /balance.
+func TestHTTPFillsReloadBalances(t *testing.T) {
+	f, c := newFake(t)
+	m := fakeprobit.NewTestPair(t, c, testPair, market.BaseStrategy{})
+	if err := c.RegisterPair(testPair, m); err != nil {
+		t.Fatal(err)
+	}
+	coin := func() decimal.Decimal {
+		var total decimal.Decimal
+		m.Do(func(m *market.MarketPair) { total, _ = m.GetBalanceAndAvail() })
+		return total
+	}
+	if b := coin(); !b.Equal(dec("1000")) {
+		t.Fatalf("BTC %s, want 1000", b)
+	}
+
+	var err error
+	m.Do(func(m *market.MarketPair) {
+		if _, err = m.NewOrder(limit("buy", "100", "2")); err == nil {
+			err = m.UpdateMyOrders()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = f.PlaceExternal(testPair, "sell", dec("100"), dec("0.5")); err != nil {
+		t.Fatal(err)
+	}
+	m.Do(func(m *market.MarketPair) { err = m.UpdateMyOrders() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := coin(); !b.Equal(dec("1000.5")) {
+		t.Errorf("BTC %s after a partial fill, want 1000.5", b)
+	}
+
+	//filled on placement, against the ask at 100.1
+	m.Do(func(m *market.MarketPair) { _, err = m.NewOrder(limit("buy", "100.1", "1")) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := coin(); !b.Equal(dec("1001.5")) {
+		t.Errorf("BTC %s after an immediate fill, want 1001.5", b)
+	}
+}
+
+// repricePair is a pair with an own buy of 2 at 100, inside the demo spread, and
+// the book loaded if withBook.
+func repricePair(t *testing.T, withBook bool) (*fakeprobit.Server, *market.Comms, *market.MarketPair, market.CurrentOrder) {
+	t.Helper()
+	f, c := newFake(t)
+	m := fakeprobit.NewTestPair(t, c, testPair, market.BaseStrategy{})
+	if err := c.RegisterPair(testPair, m); err != nil {
+		t.Fatal(err)
+	}
+	var o market.CurrentOrder
+	var err error
+	m.Do(func(m *market.MarketPair) {
+		if withBook {
+			if err = m.UpdateMarketHttp(); err != nil {
+				return
+			}
+		}
+		o, err = m.NewOrder(limit("buy", "100", "2"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, c, m, o
+}
+
+// reprice moves id to price on the pair's loop.
+func reprice(m *market.MarketPair, id string, price string) (market.CurrentOrder, error) {
+	var n market.CurrentOrder
+	var err error
+	m.Do(func(m *market.MarketPair) { n, err = m.Reprice(id, dec(price)) })
+	return n, err
+}
+
+// openOrders fails the test unless the exchange has exactly the open orders want, as price:quantity.
+func openOrders(t *testing.T, c *market.Comms, want ...string) {
+	t.Helper()
+	open, err := c.GetMyOrdersPair(testPair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range open {
+		got = append(got, dec(d.LimitPrice).String()+":"+dec(d.OpenQuantity).String())
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("open orders %v, want %v", got, want)
+	}
+}
+
+func TestReprice(t *testing.T) {
+	_, c, m, o := repricePair(t, true)
+	n, err := reprice(m, o.ID, "99.95")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.ID == o.ID || !dec(n.LimitPrice).Equal(dec("99.95")) || !dec(n.OpenQuantity).Equal(dec("2")) {
+		t.Fatalf("replacement %+v, want a new order of 2 at 99.95", n)
+	}
+	openOrders(t, c, "99.95:2")
+	if s := m.Snapshot(); len(s.MyOrders) != 1 || s.MyOrders[0].ID != n.ID {
+		t.Errorf("own orders %+v, want only the replacement", s.MyOrders)
+	}
+}
+
+// Without asks in the book a buy can't cross, it is repriced.
+func TestRepriceEmptySide(t *testing.T) {
+	_, c, m, o := repricePair(t, false)
+	if _, err := reprice(m, o.ID, "100.05"); err != nil {
+		t.Fatal(err)
+	}
+	openOrders(t, c, "100.05:2")
+}
+
+func TestRepriceCrosses(t *testing.T) {
+	_, c, m, o := repricePair(t, true)
+	if _, err := reprice(m, o.ID, "100.1"); err != market.ErrRepriceCrosses {
+		t.Fatalf("reprice to the best ask: %v, want ErrRepriceCrosses", err)
+	}
+	openOrders(t, c, "100:2")
+}
+
+// Only the quantity the cancel took off the book is put again.
+func TestRepricePartialFill(t *testing.T) {
+	f, c, m, o := repricePair(t, true)
+	if err := f.PlaceExternal(testPair, "sell", dec("100"), dec("0.5")); err != nil {
+		t.Fatal(err)
+	}
+	n, err := reprice(m, o.ID, "99.95")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dec(n.Quantity).Equal(dec("1.5")) {
+		t.Fatalf("replacement quantity %s, want 1.5", n.Quantity)
+	}
+	openOrders(t, c, "99.95:1.5")
+}
+
+func TestRepriceCancelFailed(t *testing.T) {
+	f, c, m, o := repricePair(t, true)
+	f.Reject("cancel_order", "INTERNAL_ERROR")
+	if _, err := reprice(m, o.ID, "99.95"); err == nil || errors.Is(err, market.ErrOrderNotFound) {
+		t.Fatalf("reprice with a failing cancel: %v, want the cancel's error", err)
+	}
+	openOrders(t, c, "100:2")
+	if s := m.Snapshot(); len(s.MyOrders) != 1 || s.MyOrders[0].ID != o.ID {
+		t.Errorf("own orders %+v, want the order kept", s.MyOrders)
+	}
+}
+
+// An order filled before the cancel is dropped and not put again.
+func TestRepriceOrderFilled(t *testing.T) {
+	f, c, m, o := repricePair(t, true)
+	if err := f.PlaceExternal(testPair, "sell", dec("100"), dec("2")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reprice(m, o.ID, "99.95"); !errors.Is(err, market.ErrOrderNotFound) {
+		t.Fatalf("reprice of a filled order: %v, want ErrOrderNotFound", err)
+	}
+	openOrders(t, c)
+	if s := m.Snapshot(); len(s.MyOrders) != 0 {
+		t.Errorf("own orders %+v, want none", s.MyOrders)
+	}
+}
+
+func TestRepriceRestTooSmall(t *testing.T) {
+	f, c, m, o := repricePair(t, true)
+	if err := f.PlaceExternal(testPair, "sell", dec("100"), dec("1.995")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reprice(m, o.ID, "99.95"); err != market.ErrRepriceTooSmall {
+		t.Fatalf("reprice of a rest below the min cost: %v, want ErrRepriceTooSmall", err)
+	}
+	openOrders(t, c)
+}
+
+// Fills of polled orders are read from the trade history and passed to OnFill once,
+// also when the private channels push them afterwards.
+func TestHTTPFillsPassedOnce(t *testing.T) {
+	f, c := newFake(t)
+	rec := newRecorder()
+	m := fakeprobit.NewTestPair(t, c, testPair, rec)
+	if err := c.RegisterPair(testPair, m); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	m.Do(func(m *market.MarketPair) {
+		if _, err = m.NewOrder(limit("buy", "100", "2")); err == nil {
+			err = m.UpdateMyOrders()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fill := func() []market.MyTrade {
+		t.Helper()
+		if err := f.PlaceExternal(testPair, "sell", dec("100"), dec("0.5")); err != nil {
+			t.Fatal(err)
+		}
+		m.Do(func(m *market.MarketPair) { err = m.UpdateMyOrders() })
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case l := <-rec.fills:
+			if len(l) != 1 || !dec(l[0].Quantity).Equal(dec("0.5")) {
+				t.Fatalf("fills %+v, want one of 0.5", l)
+			}
+			return l
+		default:
+			t.Fatal("no fill passed")
+		}
+		return nil
+	}
+	first := fill()
+	second := fill()
+	if first[0].ID == second[0].ID {
+		t.Error("the first fill passed again")
+	}
+	m.PushMyTrades(append(first, second...))
+	m.Do(func(m *market.MarketPair) { err = m.UpdateMyOrders() })
+	select {
+	case l := <-rec.fills:
+		t.Errorf("fills passed again: %+v", l)
+	default:
+	}
+}
@@ -8,26 +8,54 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/sacOO7/gowebsocket"
 	"github.com/shopspring/decimal"
+	"go.uber.org/multierr"
 )
 
 type marketPairer interface {
 	SetIncrement(i decimal.Decimal)
 	UpdateMarketData(d MarketData)
+	LoadMyOrders()
+	//private channels, see private.go
+	PushMyOrders(orders []CurrentOrder, reset bool)
+	PushMyTrades(trades []MyTrade)
+	PushBalances(changed map[string]CoinBalance)
+	SocketLost()
 }
 type Comms struct {
-	socket      gowebsocket.Socket
-	Token       AuthToken
+	socket      *gowebsocket.Socket
+	Token       AuthToken //guarded by authMu, read it with token()
+	authMu      sync.RWMutex
 	marketPairs map[string]marketPairer
-	Specs       httpMarketSpec
+	pairsMu     sync.RWMutex
+	Specs       httpMarketSpec //guarded by specMu
+	specMu      sync.RWMutex
 	toBeClosed  bool
 
+	//websocket supervision, see supervisor.go
+	sockMu        sync.Mutex
+	sockGen       int //incremented on every successful dial
+	connState     ConnState
+	connAttempt   int
+	connObservers []func(e ConnEvent)
+	lost          chan socketLost
+	done          chan struct{}
+	lastRX        time.Time
+
+	//marketdata subscriptions, see subscription.go
+	subMu   sync.Mutex
+	subs    map[string]*Subscription
+	filters map[string][]string
+
 	myProbID, myProbSecret string
-	RateLimitTimeout       time.Time
+	limiter                *rateLimiter
+	balances               *BalanceBook
+	opts                   CommsOptions
+	rx                     *dispatcher
 	//orders can be updated by socket/subscribe if timing is important; no pair is specified
 	infoLog *log.Logger
 	warnLog *log.Logger
@@ -40,7 +68,7 @@ type AuthToken struct {
 	ExpiresIn   int    `json:"expires_in"`
 	ExpiryTime  time.Time
 }
-type currentOrder struct {
+type CurrentOrder struct {
 	ID                string    `json:"id"`
 	UserID            string    `json:"user_id"`
 	MarketID          string    `json:"market_id"`
@@ -59,42 +87,51 @@ type currentOrder struct {
 }
 
 type CurrentOrdersAll struct {
-	Data []currentOrder `json:"data"`
+	Data []CurrentOrder `json:"data"`
 }
 
 type newOrderJson struct {
-	Data currentOrder `json:"data"`
+	Data CurrentOrder `json:"data"`
 }
 
 type httpMarketSpec struct {
-	Data []pairSpec `json:"data"`
+	Data []PairSpec `json:"data"`
 }
 
-func NewComms(info *log.Logger, warn *log.Logger, erro *log.Logger) *Comms {
-	c := Comms{infoLog: info, warnLog: warn, errLog: erro}
+func NewComms(opts CommsOptions, info *log.Logger, warn *log.Logger, erro *log.Logger) *Comms {
+	c := Comms{opts: opts.withDefaults(), infoLog: info, warnLog: warn, errLog: erro}
+	c.limiter = newRateLimiter(c.opts.RateBudgets)
+	c.balances = newBalanceBook()
+	c.balances.notify = c.pushBalances
 	c.marketPairs = make(map[string]marketPairer)
+	c.subs = make(map[string]*Subscription)
+	c.filters = make(map[string][]string)
+	c.rx = newDispatcher()
+	c.registerHandlers()
 
-	content, err := ioutil.ReadFile("probID.txt")
-	if err != nil {
-		erro.Println("ID file error", err)
-		return nil
-	}
-	c.myProbID = string(content)
-	content, err = ioutil.ReadFile("probSecret.txt")
+	id, secret, err := c.opts.Credentials.Credentials()
 	if err != nil {
-		erro.Println("secter file error", err)
+		erro.Println("credentials error:", err)
 		return nil
 	}
-	c.myProbSecret = string(content)
+	c.myProbID, c.myProbSecret = id, secret
 	return &c
 }
 
+// token is a copy of the current token; keepAuth replaces it from another goroutine.
+func (o *Comms) token() AuthToken {
+	o.authMu.RLock()
+	defer o.authMu.RUnlock()
+	return o.Token
+}
+
 func (o *Comms) NeedsAuth() bool {
 	nilAuth := AuthToken{}
-	if o.Token == nilAuth {
+	t := o.token()
+	if t == nilAuth {
 		return true
 	}
-	if time.Now().Add(time.Minute).After(o.Token.ExpiryTime) {
+	if time.Now().Add(time.Minute).After(t.ExpiryTime) {
 		return true
 	}
 	return false
@@ -107,22 +144,21 @@ func (o *Comms) AuthSocket() {
 	command := `{ 
 			"type": "authorization",
 	"token": "`
-	command = command + o.Token.AccessToken
+	command = command + o.token().AccessToken
 	command = command + `"
 	}`
 	o.infoLog.Println(command)
-	o.socket.SendText(command)
+	o.send(command)
 }
 
 func (o *Comms) keepAuth() {
 	for {
 		if o.NeedsAuth() {
 			o.warnLog.Println("Needs auth")
-			if time.Now().Before(o.RateLimitTimeout) {
-				o.warnLog.Println("auth waiting for rate timeout")
-				for time.Now().Before(o.RateLimitTimeout) {
-					time.Sleep(10 * time.Millisecond)
-				}
+			if until := o.ClassRateLimitedUntil(ClassAuth); time.Now().Before(until) {
+				//a token request would only fail with ErrRateLimited until then
+				o.warnLog.Println("auth waiting for rate timeout until", until.Format(time.RFC3339))
+				time.Sleep(time.Until(until))
 			}
 			tok, err := o.GetNewToken(o.myProbID, o.myProbSecret)
 			if tok == "" || err != nil {
@@ -139,20 +175,23 @@ func (o *Comms) StartAuth() {
 	for o.NeedsAuth() {
 		time.Sleep(500 * time.Millisecond)
 	}
+	go o.keepBalances()
 }
 
-func (o Comms) GetMarketSpec(p string) (pairSpec, error) {
-	//from whole market specs, find and return pairSpec
+func (o *Comms) GetMarketSpec(p string) (PairSpec, error) {
+	//from whole market specs, find and return PairSpec
+	o.specMu.RLock()
+	defer o.specMu.RUnlock()
 	for _, s := range o.Specs.Data {
 		if s.ID == p {
 			return s, nil
 		}
 	}
-	return pairSpec{}, errors.New("pairSpec not found")
+	return PairSpec{}, errors.New("pairSpec not found")
 }
 func (o *Comms) FetchAllMarketSpecs() error {
 	//get whole market specs once and store it
-	req, e := http.NewRequest("GET", "https://api.probit.com/api/exchange/v1/market", nil)
+	req, e := http.NewRequest("GET", o.opts.RestURL+"/api/exchange/v1/market", nil)
 	if e != nil {
 		o.errLog.Println("Error in get market specs:", e)
 		return e
@@ -162,7 +201,7 @@ func (o *Comms) FetchAllMarketSpecs() error {
 	req.URL.RawQuery = q.Encode()
 	o.infoLog.Println(req.URL.String())
 
-	resp, err := http.Get(req.URL.String())
+	resp, err := o.do(ClassPublic, req)
 	if err != nil {
 		o.errLog.Println("Error in  http.Get:", err)
 		return err
@@ -174,14 +213,22 @@ func (o *Comms) FetchAllMarketSpecs() error {
 		o.errLog.Println("io err:", e)
 		return e
 	}
-	err = json.Unmarshal(b, &o.Specs) //!!TODO:
+	if err = checkAPIError(resp, b); err != nil {
+		o.errLog.Println("error in get market specs:", err)
+		return err
+	}
+	specs := httpMarketSpec{}
+	err = json.Unmarshal(b, &specs) //!!TODO:
 	if err != nil {
 		o.errLog.Println("error in reading spec: maybe: size of reader buffer:", err)
 		o.errLog.Println(resp.Status)
 		return err
 	} else {
-		o.warnLog.Println("market specs stored, len of spec:", len(o.Specs.Data))
+		o.warnLog.Println("market specs stored, len of spec:", len(specs.Data))
 	}
+	o.specMu.Lock()
+	o.Specs = specs
+	o.specMu.Unlock()
 	return nil
 }
 
@@ -200,7 +247,7 @@ func (o *Comms) GetNewToken(id string, secret string) (string, error) {
 	})
 	responseBody := bytes.NewBuffer(postBody)
 
-	req, e := http.NewRequest("POST", "https://accounts.probit.com/token", responseBody)
+	req, e := http.NewRequest("POST", o.opts.AuthURL+"/token", responseBody)
 	if e != nil {
 		o.errLog.Println("error in new token req:", e)
 		return "", e
@@ -210,7 +257,7 @@ func (o *Comms) GetNewToken(id string, secret string) (string, error) {
 	req.Header.Add("Authorization", basic)
 	req.Header.Add("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := o.do(ClassAuth, req)
 	if resp == nil || err != nil {
 		o.errLog.Println("error new token send:", err)
 		return "", err
@@ -220,23 +267,32 @@ func (o *Comms) GetNewToken(id string, secret string) (string, error) {
 
 	b, e := ioutil.ReadAll(resp.Body)
 
-	if err != nil {
-		o.errLog.Println("error in reading POST response:", err)
+	if e != nil {
+		o.errLog.Println("error in reading POST response:", e)
+		return "", e
+	}
+	if err = checkAPIError(resp, b); err != nil {
+		o.errLog.Println("error in new token:", err)
 		return "", err
 	}
 	o.infoLog.Println(string(b))
-	err = json.Unmarshal(b, &o.Token)
+	t := AuthToken{}
+	err = json.Unmarshal(b, &t)
 	if err != nil {
 		o.errLog.Println("error in unmarshaling token:", err)
 		o.errLog.Println(resp.Status)
 		return "", err
 	}
-	o.Token.ExpiryTime = time.Now().Add(time.Second * time.Duration(o.Token.ExpiresIn))
-	o.infoLog.Println("New Token. expiry time:", o.Token.ExpiryTime.Format("15:04:05.000"))
-	return o.Token.AccessToken, nil
+	t.ExpiryTime = time.Now().Add(time.Second * time.Duration(t.ExpiresIn))
+	o.authMu.Lock()
+	o.Token = t
+	o.authMu.Unlock()
+	o.infoLog.Println("New Token. expiry time:", t.ExpiryTime.Format("15:04:05.000"))
+	return t.AccessToken, nil
 }
 
-func (o *Comms) NewOrder(r Order) error {
+// NewOrder places r and returns the order as created by the exchange.
+func (o *Comms) NewOrder(r Order) (CurrentOrder, error) {
 	//https://docs-en.probit.com/reference#order-1
 	//https://blog.logrocket.com/making-http-requests-in-go/
 
@@ -248,96 +304,97 @@ func (o *Comms) NewOrder(r Order) error {
 	postBody, _ := json.Marshal(r)
 	responseBody := bytes.NewBuffer(postBody)
 	//	o.infoLog.Println(string(responseBody.Bytes()))
-	req, e := http.NewRequest("POST", "https://api.probit.com/api/exchange/v1/new_order", responseBody)
+	req, e := http.NewRequest("POST", o.opts.RestURL+"/api/exchange/v1/new_order", responseBody)
 	if e != nil {
 		o.errLog.Println("error in preparing new order:", e)
-		return e
+		return CurrentOrder{}, e
 	}
 
 	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Authorization", "Bearer "+o.Token.AccessToken)
+	req.Header.Add("Authorization", "Bearer "+o.token().AccessToken)
 	req.Header.Add("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := o.do(ClassOrder, req)
 	if err != nil {
 		o.errLog.Println("error in sending new order :", err)
-		return err
+		return CurrentOrder{}, err
 	}
 	defer resp.Body.Close()
 
 	b, e := ioutil.ReadAll(resp.Body)
-	s := string(b)
-	if strings.Contains(s, `"NOT_ENOUGH_BALANCE"`) {
-		o.infoLog.Println(".")
-		return nil
+	if e != nil {
+		o.errLog.Println("error in reading POST response:", e)
+		return CurrentOrder{}, e
+	}
+	if err = checkAPIError(resp, b); err != nil {
+		if errors.Is(err, ErrInsufficientBalance) {
+			o.infoLog.Println("new order rejected:", r.MarketID, r.Side, err)
+		} else {
+			o.errLog.Println("new order rejected:", r.MarketID, r.Side, err)
+		}
+		return CurrentOrder{}, err
 	}
 	o.infoLog.Println(string(b))
-	if err != nil {
-		o.errLog.Println("error in reading POST response:", err)
-		return err
-	}
 
 	newOrder := newOrderJson{}
 	err = json.Unmarshal(b, &newOrder)
 	if err != nil {
 		o.errLog.Println("error in unmarshaling newOrder:", err)
 		o.errLog.Println(resp.Status)
-		if strings.Contains(resp.Status, "Too Many") {
-			o.RateLimitTimeout = time.Now().Add(time.Second * 120)
-			o.errLog.Println("Rate Timeout:", o.RateLimitTimeout, time.Now())
-		}
-		return err
+		return CurrentOrder{}, err
 	}
-	// if newOrder.OpenQuantity != r.Quantity {
-	// 	//o.errLog.Println("wrong parameters executed for the new order")
-	// 	//TODO: newOrder needs to be slice of currentorders
-	// }
-	//o.errLog.Println(newOrder.Status)
-	return nil
+	if s, e := o.GetMarketSpec(newOrder.Data.MarketID); e == nil && newOrder.Data.Status == "open" {
+		o.balances.reserve(s, newOrder.Data)
+	}
+	if f, _ := decimal.NewFromString(newOrder.Data.FilledQuantity); f.IsPositive() {
+		o.balances.invalidate() //filled on placement
+	}
+	return newOrder.Data, nil
 }
 
-func (o *Comms) CancelOrder(c cancelingOrder) error {
+// CancelOrder cancels an own order; the returned order has the quantity taken off
+// the book as CancelledQuantity, or is empty if the response can't be read.
+func (o *Comms) CancelOrder(c CancelingOrder) (CurrentOrder, error) {
 	postBody, _ := json.Marshal(c)
 	responseBody := bytes.NewBuffer(postBody)
-	req, e := http.NewRequest("POST", "https://api.probit.com/api/exchange/v1/cancel_order", responseBody)
+	req, e := http.NewRequest("POST", o.opts.RestURL+"/api/exchange/v1/cancel_order", responseBody)
 	if e != nil {
 		o.errLog.Print(e)
-		return e
+		return CurrentOrder{}, e
 	}
 
 	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Authorization", "Bearer "+o.Token.AccessToken)
+	req.Header.Add("Authorization", "Bearer "+o.token().AccessToken)
 	req.Header.Add("Content-Type", "application/json")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := o.do(ClassOrder, req)
 	if err != nil {
 		o.errLog.Println("error :", err)
-		return e
+		return CurrentOrder{}, err
 	}
 	defer resp.Body.Close()
 
-	_, e = ioutil.ReadAll(resp.Body)
-	if err != nil {
-		o.errLog.Println("error in reading POST response:", err)
+	b, e := ioutil.ReadAll(resp.Body)
+	if e != nil {
+		o.errLog.Println("error in reading POST response:", e)
 		o.errLog.Println(resp.Status)
-		if strings.Contains(resp.Status, "Too Many") {
-			o.RateLimitTimeout = time.Now().Add(time.Second * 120)
-			o.errLog.Println("Rate Timeout:", o.RateLimitTimeout, time.Now())
+		return CurrentOrder{}, e
+	}
+	if err = checkAPIError(resp, b); err != nil {
+		o.errLog.Println("cancel order rejected:", c.OrderID, err)
+		return CurrentOrder{}, err
+	}
+	canceled := newOrderJson{}
+	if json.Unmarshal(b, &canceled) == nil {
+		if s, e := o.GetMarketSpec(c.MarketID); e == nil {
+			o.balances.release(s, canceled.Data)
 		}
-		return e
 	}
-	// err = json.Unmarshal(b, &o.token)
-	// if err != nil {
-	// 	o.errLog.Println("error in unmarshaling token:", err)
-	// 	return
-	// }
-	// o.token.ExpiryTime = time.Now().Add(time.Second * time.Duration(o.token.ExpiresIn-10))
-	// o.errLog.Println("New Toke. expiry time:", o.token.ExpiryTime)
-	return nil
+	return canceled.Data, nil
 }
-func (o *Comms) GetMyOrdersPair(p string) ([]currentOrder, error) {
+func (o *Comms) GetMyOrdersPair(p string) ([]CurrentOrder, error) {
 	orders := CurrentOrdersAll{}
-	req, e := http.NewRequest("GET", "https://api.probit.com/api/exchange/v1/open_order", nil)
+	req, e := http.NewRequest("GET", o.opts.RestURL+"/api/exchange/v1/open_order", nil)
 	if e != nil {
 		o.errLog.Println("Error in get orders:", e)
 		return orders.Data, e
@@ -346,9 +403,9 @@ func (o *Comms) GetMyOrdersPair(p string) ([]currentOrder, error) {
 	q.Add("market_id", p)
 	req.URL.RawQuery = q.Encode()
 	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Authorization", "Bearer "+o.Token.AccessToken)
+	req.Header.Add("Authorization", "Bearer "+o.token().AccessToken)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := o.do(ClassAccount, req)
 	if err != nil {
 		o.errLog.Println("Error in  http.Get:", err)
 		return orders.Data, err
@@ -360,14 +417,14 @@ func (o *Comms) GetMyOrdersPair(p string) ([]currentOrder, error) {
 		o.errLog.Println("io err:", e)
 		return orders.Data, e
 	}
+	if err = checkAPIError(resp, b); err != nil {
+		o.errLog.Println("error in get orders:", err)
+		return orders.Data, err
+	}
 	err = json.Unmarshal(b, &orders)
 	if err != nil {
 		o.errLog.Println("error in reading orders:", err)
 		o.errLog.Println(resp.Status)
-		if strings.Contains(resp.Status, "Too Many") {
-			o.RateLimitTimeout = time.Now().Add(time.Second * 650)
-			o.errLog.Println("Rate Timeout:", o.RateLimitTimeout, time.Now())
-		}
 		return orders.Data, err
 	}
 	return orders.Data, nil
@@ -382,76 +439,93 @@ type Balance struct {
 	} `json:"data"`
 }
 
-func (o Comms) GetBalanceAndAvail(co string) (decimal.Decimal, decimal.Decimal) {
-
-	req, e := http.NewRequest("GET", "https://api.probit.com/api/exchange/v1/balance", nil)
+// FetchBalances loads every currency's balance from /balance into the balance book.
+func (o *Comms) FetchBalances() error {
+	req, e := http.NewRequest("GET", o.opts.RestURL+"/api/exchange/v1/balance", nil)
 	if e != nil {
 		o.errLog.Println("Error in get balance:", e)
-		return decimal.Zero, decimal.Zero
+		return e
 	}
-	q := req.URL.Query()
-	//	q.Add("market_id", p)
-	req.URL.RawQuery = q.Encode()
 	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Authorization", "Bearer "+o.Token.AccessToken)
+	req.Header.Add("Authorization", "Bearer "+o.token().AccessToken)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := o.do(ClassAccount, req)
 	if err != nil {
 		o.errLog.Println("Error in  http.Get balance:", err)
-		return decimal.Zero, decimal.Zero
+		return err
 	}
 	defer resp.Body.Close()
 
 	b, e := ioutil.ReadAll(resp.Body)
 	if e != nil {
 		o.errLog.Println("io err:", e)
-		return decimal.Zero, decimal.Zero
+		return e
+	}
+	if err = checkAPIError(resp, b); err != nil {
+		o.errLog.Println("error in get balance:", err)
+		return err
 	}
 	var bl Balance
 	err = json.Unmarshal(b, &bl)
 	if err != nil {
 		o.errLog.Println("error in reading balance:", err)
 		o.errLog.Println(resp.Status)
-		if strings.Contains(resp.Status, "Too Many") {
-			o.RateLimitTimeout = time.Now().Add(time.Second * 650)
-			o.errLog.Println("Rate Timeout:", o.RateLimitTimeout, time.Now())
-		}
-		return decimal.Zero, decimal.Zero
+		return err
 	}
+	coins := make(map[string]CoinBalance)
 	for _, d := range bl.Data {
-		if d.CurrencyID == co {
-			total, err1 := decimal.NewFromString(d.Total)
-			avail, err2 := decimal.NewFromString(d.Available)
-			if err1 != nil || err2 != nil {
-				o.errLog.Println("error in reading total/avail:", err)
-				return decimal.Zero, decimal.Zero
-			}
-			return total, avail
+		total, err1 := decimal.NewFromString(d.Total)
+		avail, err2 := decimal.NewFromString(d.Available)
+		if err1 != nil || err2 != nil {
+			o.errLog.Println("error in reading total/avail:", d.CurrencyID, d.Total, d.Available)
+			return multierr.Append(err1, err2)
 		}
+		coins[d.CurrencyID] = CoinBalance{Total: total, Avail: avail}
 	}
-	return decimal.Zero, decimal.Zero
+	o.balances.load(coins)
+	return nil
+}
+
+// InvalidateBalances makes the next GetBalanceAndAvail load /balance, unless the
+// balance channel keeps the book current. Call it when own fills are seen over HTTP.
+func (o *Comms) InvalidateBalances() {
+	o.balances.invalidate()
+}
 
+// GetBalanceAndAvail reads the balance book, loading it first if needed.
+func (o *Comms) GetBalanceAndAvail(co string) (decimal.Decimal, decimal.Decimal) {
+	b, found := o.balances.Get(co)
+	if !found {
+		if err := o.FetchBalances(); err != nil {
+			return decimal.Zero, decimal.Zero
+		}
+		b, _ = o.balances.Get(co)
+	}
+	return b.Total, b.Avail
 }
 
 type TradeHistory struct {
-	Data []struct {
-		ID            string    `json:"id"`
-		OrderID       string    `json:"order_id"`
-		Side          string    `json:"side"`
-		FeeAmount     string    `json:"fee_amount"`
-		FeeCurrencyID string    `json:"fee_currency_id"`
-		Status        string    `json:"status"`
-		Price         string    `json:"price"`
-		Quantity      string    `json:"quantity"`
-		Cost          string    `json:"cost"`
-		Time          time.Time `json:"time"`
-		MarketID      string    `json:"market_id"`
-	} `json:"data"`
+	Data []MyTrade `json:"data"`
+}
+
+// MyTrade is a fill of an own order, from /trade_history or the trade_history channel.
+type MyTrade struct {
+	ID            string    `json:"id"`
+	OrderID       string    `json:"order_id"`
+	Side          string    `json:"side"`
+	FeeAmount     string    `json:"fee_amount"`
+	FeeCurrencyID string    `json:"fee_currency_id"`
+	Status        string    `json:"status"`
+	Price         string    `json:"price"`
+	Quantity      string    `json:"quantity"`
+	Cost          string    `json:"cost"`
+	Time          time.Time `json:"time"`
+	MarketID      string    `json:"market_id"`
 }
 
-func (o Comms) GetTradeHistory(p string, start time.Time, end time.Time) (*TradeHistory, error) {
+func (o *Comms) GetTradeHistory(p string, start time.Time, end time.Time) (*TradeHistory, error) {
 
-	req, e := http.NewRequest("GET", "https://api.probit.com/api/exchange/v1/trade_history", nil)
+	req, e := http.NewRequest("GET", o.opts.RestURL+"/api/exchange/v1/trade_history", nil)
 	if e != nil {
 		o.errLog.Println("Error in get history:", e)
 		return nil, e
@@ -463,9 +537,9 @@ func (o Comms) GetTradeHistory(p string, start time.Time, end time.Time) (*Trade
 	q.Add("start_time", start.UTC().Format("2006-01-02T15:04:05")+".000Z")
 	req.URL.RawQuery = q.Encode()
 	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Authorization", "Bearer "+o.Token.AccessToken)
+	req.Header.Add("Authorization", "Bearer "+o.token().AccessToken)
 	o.infoLog.Println(req.URL.String())
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := o.do(ClassAccount, req)
 	if err != nil {
 		o.errLog.Println("Error in  history resp:", err)
 		return nil, err
@@ -477,6 +551,10 @@ func (o Comms) GetTradeHistory(p string, start time.Time, end time.Time) (*Trade
 		o.errLog.Println("io err:", e)
 		return nil, e
 	}
+	if err = checkAPIError(resp, b); err != nil {
+		o.errLog.Println("error in get history:", err)
+		return nil, err
+	}
 	h := TradeHistory{}
 	err = json.Unmarshal(b, &h)
 	if err != nil {
@@ -488,10 +566,10 @@ func (o Comms) GetTradeHistory(p string, start time.Time, end time.Time) (*Trade
 
 }
 
-type marketTrades struct {
-	Data []marketTrade `json:"data"`
+type MarketTrades struct {
+	Data []MarketTrade `json:"data"`
 }
-type marketTrade struct {
+type MarketTrade struct {
 	ID            string    `json:"id"`
 	Price         string    `json:"price"`
 	Quantity      string    `json:"quantity"`
@@ -501,7 +579,7 @@ type marketTrade struct {
 }
 
 //old structure based on the documentation:
-// type marketTrade struct {
+// type MarketTrade struct {
 // 	ID            string    `json:"id"`
 // 	OrderID       string    `json:"order_id"`
 // 	Side          string    `json:"side"` //side is buy if the order had been there as a buy when a seller made the trade
@@ -515,8 +593,8 @@ type marketTrade struct {
 // 	MarketID      string    `json:"market_id"`
 // }
 
-func (o *Comms) GetMarketTrades(p string, start time.Time, end time.Time) (*marketTrades, error) {
-	req, e := http.NewRequest("GET", "https://api.probit.com/api/exchange/v1/trade", nil)
+func (o *Comms) GetMarketTrades(p string, start time.Time, end time.Time) (*MarketTrades, error) {
+	req, e := http.NewRequest("GET", o.opts.RestURL+"/api/exchange/v1/trade", nil)
 	if e != nil {
 		o.errLog.Println("Error in get market trades:", e)
 		return nil, e
@@ -528,9 +606,9 @@ func (o *Comms) GetMarketTrades(p string, start time.Time, end time.Time) (*mark
 	q.Add("start_time", start.UTC().Format("2006-01-02T15:04:05")+".000Z")
 	req.URL.RawQuery = q.Encode()
 	// req.Header.Add("Accept", "application/json")
-	// req.Header.Add("Authorization", "Bearer "+o.Token.AccessToken)
+	// req.Header.Add("Authorization", "Bearer "+o.token().AccessToken)
 	o.infoLog.Println(req.URL.String())
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := o.do(ClassPublic, req)
 	if err != nil {
 		o.errLog.Println("Error in market trade resp:", err)
 		return nil, err
@@ -542,7 +620,11 @@ func (o *Comms) GetMarketTrades(p string, start time.Time, end time.Time) (*mark
 		o.errLog.Println("io err:", e)
 		return nil, e
 	}
-	h := marketTrades{}
+	if err = checkAPIError(resp, b); err != nil {
+		o.errLog.Println("error in get market trades:", err)
+		return nil, err
+	}
+	h := MarketTrades{}
 	err = json.Unmarshal(b, &h)
 	if err != nil {
 		o.errLog.Println("error in reading market trades:", err)
@@ -553,18 +635,18 @@ func (o *Comms) GetMarketTrades(p string, start time.Time, end time.Time) (*mark
 
 }
 
-type marketOrders struct {
-	Data []marketOrder `json:"data"`
+type MarketOrders struct {
+	Data []MarketOrder `json:"data"`
 }
-type marketOrder struct {
+type MarketOrder struct {
 	Side     string `json:"side"`
 	Price    string `json:"price"`
 	Quantity string `json:"quantity"`
 }
 
-func (o *Comms) GetMarketOrdersHttp(p string) (*marketOrders, error) {
+func (o *Comms) GetMarketOrdersHttp(p string) (*MarketOrders, error) {
 
-	req, e := http.NewRequest("GET", "https://api.probit.com/api/exchange/v1/order_book", nil)
+	req, e := http.NewRequest("GET", o.opts.RestURL+"/api/exchange/v1/order_book", nil)
 	if e != nil {
 		o.errLog.Println("Error in get market orders:", e)
 		return nil, e
@@ -573,8 +655,8 @@ func (o *Comms) GetMarketOrdersHttp(p string) (*marketOrders, error) {
 	q.Add("market_id", p)
 	req.URL.RawQuery = q.Encode()
 	// req.Header.Add("Accept", "application/json")
-	// req.Header.Add("Authorization", "Bearer "+o.Token.AccessToken)
-	resp, err := http.DefaultClient.Do(req)
+	// req.Header.Add("Authorization", "Bearer "+o.token().AccessToken)
+	resp, err := o.do(ClassPublic, req)
 	if err != nil {
 		o.errLog.Println("Error in market orders resp:", err)
 		return nil, err
@@ -586,21 +668,18 @@ func (o *Comms) GetMarketOrdersHttp(p string) (*marketOrders, error) {
 		o.errLog.Println("io err:", e)
 		return nil, e
 	}
-	h := marketOrders{}
+	if err = checkAPIError(resp, b); err != nil {
+		o.errLog.Println("error in get market orders:", err)
+		return nil, err
+	}
+	h := MarketOrders{}
 	err = json.Unmarshal(b, &h)
 	if err != nil {
 		//o.errLog.Println("rate limit hit. test counters:", o.testEnt, o.testExit)
 		//o.errLog.Println("error in reading market orders:", err)
 		o.errLog.Println(resp.Status)
-		if strings.Contains(resp.Status, "Too Many") {
-			o.RateLimitTimeout = time.Now().Add(time.Second * 650)
-			o.errLog.Println("Rate Timeout:", o.RateLimitTimeout, time.Now())
-		}
 		return nil, err
 	}
-	//resp.Header "Retry-After" [0] -> number of seconds before gate reopens
-	//605(5.34.04pm)562(5.34.48pm)
-	//resp.Satus "429 Too Many Requests"
 	return &h, nil
 
 }
@@ -608,54 +687,28 @@ func (o *Comms) GetMarketOrdersHttp(p string) (*marketOrders, error) {
 /////////////////////////////////////Socket functions
 func (o *Comms) UnregisterPair(p string) {
 	o.UnSubscribe(p)
-	if _, found := o.marketPairs[p]; found {
-		delete(o.marketPairs, p)
-	}
+	o.pairsMu.Lock()
+	delete(o.marketPairs, p)
+	o.pairsMu.Unlock()
 }
-func (o *Comms) Connect() {
-	o.socket = gowebsocket.New("wss://api.probit.com/api/exchange/v1/ws")
 
-	o.socket.OnConnected = func(socket gowebsocket.Socket) {
-		o.warnLog.Println("Connected to server")
-	}
-
-	o.socket.OnConnectError = func(err error, socket gowebsocket.Socket) {
-		o.errLog.Println("Recieved connect error ", err)
-	}
-
-	o.socket.OnTextMessage = o.handleRXMessage
-
-	o.socket.OnBinaryMessage = func(data []byte, socket gowebsocket.Socket) {
-		o.infoLog.Println("Recieved binary data ", data)
-	}
-
-	o.socket.OnPingReceived = func(data string, socket gowebsocket.Socket) {
-	}
-
-	o.socket.OnPongReceived = func(data string, socket gowebsocket.Socket) {
-		o.infoLog.Println("Recieved pong " + data)
-	}
+// pair is the registered receiver of a market's packets.
+func (o *Comms) pair(p string) (marketPairer, bool) {
+	o.pairsMu.RLock()
+	defer o.pairsMu.RUnlock()
+	m, found := o.marketPairs[p]
+	return m, found
+}
 
-	o.socket.OnDisconnected = func(err error, socket gowebsocket.Socket) {
-		o.errLog.Println("Disconnected from server ")
-		if o.toBeClosed {
-			o.warnLog.Println("intentional closing")
-			return
-		}
-		o.socket.Connect()
-		o.warnLog.Println("ReSubscribing pairs:", len(o.marketPairs))
-		for p, _ := range o.marketPairs {
-			time.Sleep(time.Millisecond * 10)
-			o.Subscribe(p)
-		}
-		return
-	}
-	o.socket.Connect()
-	o.warnLog.Println("Subscribing pairs:", len(o.marketPairs))
-	for p, _ := range o.marketPairs {
-		time.Sleep(time.Millisecond * 10)
-		o.Subscribe(p)
+// pairs is a copy of the registered pairs, safe to range while pairs are added or dropped.
+func (o *Comms) pairs() map[string]marketPairer {
+	o.pairsMu.RLock()
+	defer o.pairsMu.RUnlock()
+	l := make(map[string]marketPairer, len(o.marketPairs))
+	for p, m := range o.marketPairs {
+		l[p] = m
 	}
+	return l
 }
 func (o *Comms) RegisterPair(p string, m marketPairer) error {
 	s, err := o.GetMarketSpec(p)
@@ -666,51 +719,30 @@ func (o *Comms) RegisterPair(p string, m marketPairer) error {
 	i, e := decimal.NewFromString(s.PriceIncrement)
 	if e != nil {
 		o.errLog.Println("ERROR: parsing increment:", e)
-		return err
+		return e
 	}
-	o.marketPairs[p] = m
 	m.SetIncrement(i)
-	//	o.Subscribe(p)
+	o.pairsMu.Lock()
+	o.marketPairs[p] = m
+	o.pairsMu.Unlock()
 	return nil
 }
-func (o *Comms) Subscribe(pair string) {
-
-	command := `{ 
-			"type": "subscribe",
-	"channel": "marketdata",
-	"interval": 100,
-	"market_id": "`
-	command = command + pair
-	command = command + `",
-	"filter": ["order_books"]
-	}`
-	o.socket.SendText(command)
-}
-func (o *Comms) UnSubscribe(pair string) {
-
-	command := `{ 
-			"type": "subscribe",
-	"channel": "marketdata",
-	"interval": 100,
-	"market_id": "`
-	command = command + pair
-	command = command + `",
-	"filter": ["ticker"]
-	}`
-	o.socket.SendText(command)
-}
-func (o *Comms) receiveMarketData(message string) {
+func (o *Comms) receiveMarketData(e Envelope, message []byte) {
 	//o.marketpair.callback(MarketData)
 
 	var d MarketData
 	d.Reset = false
-	o.infoLog.Println("Recieved MarketData: " + message)
-	err := json.Unmarshal([]byte(message), &d)
+	o.infoLog.Println("Recieved MarketData: " + string(message))
+	err := json.Unmarshal(message, &d)
 	if err != nil {
 		o.errLog.Println("error unmarshaling market data: ", err)
 		return
 	}
-	m, found := o.marketPairs[d.MarketID]
+	if !o.ackSubscription(d.MarketID) {
+		o.infoLog.Println("market data received for an unsubscribed pair:", d.MarketID)
+		return
+	}
+	m, found := o.pair(d.MarketID)
 	if !found {
 		o.errLog.Println("market data received for a non-interested pair")
 		return
@@ -718,35 +750,8 @@ func (o *Comms) receiveMarketData(message string) {
 	m.UpdateMarketData(d)
 }
 
-func (o *Comms) handleRXMessage(message string, socket gowebsocket.Socket) {
-	//	if message != "{\"type\":\"authorization\",\"result\":\"ok\"}" {
-	if strings.Contains(message, `"channel":"marketdata"`) {
-		//o.updateMarketData(message, socket)
-		o.receiveMarketData(message)
-		return
-	}
-	if strings.Contains(message, `"type":"authorization","result":"ok"`) {
-		o.infoLog.Println("authorised: ", message)
-		return
-	}
-	if strings.Contains(message, `"errorCode":"UNAUTHORIZED"`) {
-		o.errLog.Println("UNauthorised: ", message)
-		return
-	}
-	//{"type":"error","message":"ping timeout"}
-	if strings.Contains(message, `"ping timeout"`) {
-		o.errLog.Println("unhandled ERROR ping timeout: ", message)
-		return
-	}
-	o.errLog.Println("unhandled packet")
-	l := len(message)
-	if l > 200 {
-		l = 200
-	}
-	o.errLog.Println(message[:l])
-}
-func (o *Comms) GetMarketOrders(p string) (*marketOrders, error) {
-	req, e := http.NewRequest("GET", "https://api.probit.com/api/exchange/v1/order_book", nil)
+func (o *Comms) GetMarketOrders(p string) (*MarketOrders, error) {
+	req, e := http.NewRequest("GET", o.opts.RestURL+"/api/exchange/v1/order_book", nil)
 	if e != nil {
 		o.errLog.Println("Error in get market orders:", e)
 		return nil, e
@@ -755,9 +760,9 @@ func (o *Comms) GetMarketOrders(p string) (*marketOrders, error) {
 	q.Add("market_id", p)
 	req.URL.RawQuery = q.Encode()
 	// req.Header.Add("Accept", "application/json")
-	// req.Header.Add("Authorization", "Bearer "+o.Token.AccessToken)
+	// req.Header.Add("Authorization", "Bearer "+o.token().AccessToken)
 	o.infoLog.Println(req.URL.String())
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := o.do(ClassPublic, req)
 	if err != nil {
 		o.errLog.Println("Error in market orders resp:", err)
 		return nil, err
@@ -770,28 +775,19 @@ func (o *Comms) GetMarketOrders(p string) (*marketOrders, error) {
 		o.errLog.Println("io err:", e)
 		return nil, e
 	}
-	h := marketOrders{}
+	if err = checkAPIError(resp, b); err != nil {
+		o.errLog.Println("error in get market orders:", err)
+		return nil, err
+	}
+	h := MarketOrders{}
 	err = json.Unmarshal(b, &h)
 	if err != nil {
 		o.errLog.Println("error in reading market orders:", err)
 		o.errLog.Println(resp.Status)
-		if strings.Contains(resp.Status, "Too Many") {
-			o.RateLimitTimeout = time.Now().Add(time.Second * 120)
-			o.errLog.Println("Rate Timeout:", o.RateLimitTimeout, time.Now())
-		}
 		return nil, err
 	}
 
 	return &h, nil
 
 }
-func (o *Comms) CloseSocket() {
-	o.toBeClosed = true
-	o.socket.Close()
-}
-func (o *Comms) OpenSocket() {
-	o.toBeClosed = false
-	o.Connect()
-	o.AuthSocket()
 
-}
@@ -0,0 +1,184 @@
+package market
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// CredentialProvider supplies the ProBit client id and secret used for /token.
+type CredentialProvider interface {
+	Credentials() (id string, secret string, err error)
+}
+
+// FileCredentials reads the id and secret from two plaintext files,
+// probID.txt and probSecret.txt by default. Surrounding whitespace is dropped.
+type FileCredentials struct {
+	IDFile     string
+	SecretFile string
+}
+
+func (o FileCredentials) Credentials() (string, string, error) {
+	if o.IDFile == "" {
+		o.IDFile = "probID.txt"
+	}
+	if o.SecretFile == "" {
+		o.SecretFile = "probSecret.txt"
+	}
+	id, err := ioutil.ReadFile(o.IDFile)
+	if err != nil {
+		return "", "", fmt.Errorf("ID file error: %w", err)
+	}
+	secret, err := ioutil.ReadFile(o.SecretFile)
+	if err != nil {
+		return "", "", fmt.Errorf("secret file error: %w", err)
+	}
+	return checkCredentials(string(id), string(secret))
+}
+
+// EnvCredentials reads PROBIT_ID and PROBIT_SECRET, or PROBIT_<ACCOUNT>_ID and
+// PROBIT_<ACCOUNT>_SECRET when Account is set.
+type EnvCredentials struct {
+	Account string
+}
+
+func (o EnvCredentials) Credentials() (string, string, error) {
+	prefix := "PROBIT_"
+	if o.Account != "" {
+		prefix += strings.ToUpper(o.Account) + "_"
+	}
+	return checkCredentials(os.Getenv(prefix+"ID"), os.Getenv(prefix+"SECRET"))
+}
+
+// KeystoreCredentials decrypts one account from a passphrase-protected keystore
+// file written by WriteKeystore.
+type KeystoreCredentials struct {
+	Path       string
+	Passphrase string
+	Account    string //"" picks "default"
+}
+
+type Credentials struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+func (o KeystoreCredentials) Credentials() (string, string, error) {
+	accounts, err := ReadKeystore(o.Path, o.Passphrase)
+	if err != nil {
+		return "", "", err
+	}
+	a := o.Account
+	if a == "" {
+		a = "default"
+	}
+	c, found := accounts[a]
+	if !found {
+		return "", "", fmt.Errorf("account %q not in keystore", a)
+	}
+	return checkCredentials(c.ID, c.Secret)
+}
+
+func checkCredentials(id string, secret string) (string, string, error) {
+	id, secret = strings.TrimSpace(id), strings.TrimSpace(secret)
+	if id == "" || secret == "" {
+		return "", "", errors.New("empty client id or secret")
+	}
+	return id, secret, nil
+}
+
+/////////////////////////////////////keystore file
+//The keystore is JSON holding an AES-256-GCM sealed map of account name to Credentials.
+//The key is PBKDF2-HMAC-SHA256 of the passphrase.
+
+const keystoreIterations = 200000
+
+type keystoreFile struct {
+	Version    int    `json:"version"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+var ErrBadPassphrase = errors.New("keystore: wrong passphrase or corrupted file")
+
+func WriteKeystore(path string, passphrase string, accounts map[string]Credentials) error {
+	if passphrase == "" {
+		return errors.New("keystore: empty passphrase")
+	}
+	plain, err := json.Marshal(accounts)
+	if err != nil {
+		return err
+	}
+	k := keystoreFile{Version: 1, Iterations: keystoreIterations, Salt: make([]byte, 16)}
+	if _, err := rand.Read(k.Salt); err != nil {
+		return err
+	}
+	gcm, err := keystoreCipher(passphrase, k.Salt, k.Iterations)
+	if err != nil {
+		return err
+	}
+	k.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(k.Nonce); err != nil {
+		return err
+	}
+	k.Data = gcm.Seal(nil, k.Nonce, plain, nil)
+	b, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+func ReadKeystore(path string, passphrase string) (map[string]Credentials, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var k keystoreFile
+	if err := json.Unmarshal(b, &k); err != nil {
+		return nil, fmt.Errorf("keystore: %w", err)
+	}
+	if k.Version != 1 || k.Iterations <= 0 {
+		return nil, fmt.Errorf("keystore: unsupported version %d", k.Version)
+	}
+	gcm, err := keystoreCipher(passphrase, k.Salt, k.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(k.Nonce) != gcm.NonceSize() {
+		return nil, ErrBadPassphrase
+	}
+	plain, err := gcm.Open(nil, k.Nonce, k.Data, nil)
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+	accounts := map[string]Credentials{}
+	if err := json.Unmarshal(plain, &accounts); err != nil {
+		return nil, fmt.Errorf("keystore: %w", err)
+	}
+	return accounts, nil
+}
+
+func keystoreCipher(passphrase string, salt []byte, iter int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(keystoreKey(passphrase, salt, iter))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// keystoreKey is the AES-256 key of a keystore, PBKDF2 with HMAC-SHA256.
+func keystoreKey(passphrase string, salt []byte, iter int) []byte {
+	return pbkdf2.Key([]byte(passphrase), salt, iter, 32, sha256.New)
+}
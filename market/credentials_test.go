@@ -0,0 +1,48 @@
+package market
+
+import (
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// The PBKDF2-HMAC-SHA256 vectors of RFC 7914 section 11; the keystore key is
+// the first 32 bytes of the 64 given there.
+func TestKeystoreKey(t *testing.T) {
+	for _, v := range []struct {
+		passphrase string
+		salt       string
+		iter       int
+		key        string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	} {
+		if k := hex.EncodeToString(keystoreKey(v.passphrase, []byte(v.salt), v.iter)); k != v.key[:64] {
+			t.Errorf("key of %q/%q/%d: %s, want %s", v.passphrase, v.salt, v.iter, k, v.key[:64])
+		}
+	}
+}
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keystore.json")
+	accounts := map[string]Credentials{"default": {ID: "id1", Secret: "secret1"}, "second": {ID: "id2", Secret: "secret2"}}
+	if err := WriteKeystore(path, "pass phrase", accounts); err != nil {
+		t.Fatal(err)
+	}
+	id, secret, err := KeystoreCredentials{Path: path, Passphrase: "pass phrase", Account: "second"}.Credentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "id2" || secret != "secret2" {
+		t.Fatalf("read %q %q, want id2 secret2", id, secret)
+	}
+	if id, _, err = (KeystoreCredentials{Path: path, Passphrase: "pass phrase"}).Credentials(); err != nil || id != "id1" {
+		t.Fatalf("default account: %q %v", id, err)
+	}
+
+	if _, err := ReadKeystore(path, "wrong"); !errors.Is(err, ErrBadPassphrase) {
+		t.Fatalf("wrong passphrase: %v, want ErrBadPassphrase", err)
+	}
+}
@@ -0,0 +1,134 @@
+package market
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Envelope is the part of every websocket message needed to route it.
+type Envelope struct {
+	Type      string `json:"type"`
+	Channel   string `json:"channel"`
+	Result    string `json:"result"`
+	ErrorCode string `json:"errorCode"`
+	Message   string `json:"message"`
+}
+
+// route is the handler key of a message: its errorCode if it has one, then its
+// channel, then its type.
+func (o Envelope) route() string {
+	if o.ErrorCode != "" {
+		return o.ErrorCode
+	}
+	if o.Channel != "" {
+		return o.Channel
+	}
+	return o.Type
+}
+
+// MessageHandler gets the decoded envelope and the raw packet to decode the rest.
+type MessageHandler func(e Envelope, message []byte)
+
+// RXStats counts received websocket messages.
+type RXStats struct {
+	Routes      map[string]int //handled messages per route
+	Unknown     int            //no handler for the route
+	Malformed   int            //not a JSON object
+	LastUnknown string         //start of the latest unknown message
+}
+
+type dispatcher struct {
+	mu       sync.RWMutex
+	handlers map[string]MessageHandler
+	stats    RXStats
+}
+
+func newDispatcher() *dispatcher {
+	return &dispatcher{handlers: make(map[string]MessageHandler), stats: RXStats{Routes: make(map[string]int)}}
+}
+
+// HandleRoute registers f for messages of a channel, type or errorCode, replacing
+// the previous handler of that route. Comms registers its own on creation.
+func (o *Comms) HandleRoute(route string, f MessageHandler) {
+	o.rx.mu.Lock()
+	defer o.rx.mu.Unlock()
+	o.rx.handlers[route] = f
+}
+
+// RXStats is a copy of the websocket message counters.
+func (o *Comms) RXStats() RXStats {
+	o.rx.mu.RLock()
+	defer o.rx.mu.RUnlock()
+	s := o.rx.stats
+	s.Routes = make(map[string]int, len(o.rx.stats.Routes))
+	for r, n := range o.rx.stats.Routes {
+		s.Routes[r] = n
+	}
+	return s
+}
+
+func (o *Comms) registerHandlers() {
+	o.HandleRoute("marketdata", o.receiveMarketData)
+	o.HandleRoute("open_order", o.receiveOrders)
+	o.HandleRoute("order_history", o.receiveOrders)
+	o.HandleRoute("trade_history", o.receiveTrades)
+	o.HandleRoute("balance", o.receiveBalance)
+	o.HandleRoute("authorization", o.receiveAuthorization)
+	o.HandleRoute("UNAUTHORIZED", o.receiveUnauthorized)
+	o.HandleRoute("error", o.receiveError)
+}
+
+// dispatch decodes the envelope once and passes the message to the handler of its route.
+func (o *Comms) dispatch(message []byte) {
+	var e Envelope
+	if err := json.Unmarshal(message, &e); err != nil {
+		o.rx.mu.Lock()
+		o.rx.stats.Malformed++
+		o.rx.mu.Unlock()
+		o.errLog.Println("malformed packet:", err)
+		return
+	}
+	r := e.route()
+	o.rx.mu.Lock()
+	f, found := o.rx.handlers[r]
+	if found {
+		o.rx.stats.Routes[r]++
+	} else {
+		o.rx.stats.Unknown++
+		l := len(message)
+		if l > 200 {
+			l = 200
+		}
+		o.rx.stats.LastUnknown = string(message[:l])
+	}
+	o.rx.mu.Unlock()
+	if !found {
+		o.infoLog.Println("unhandled packet:", r)
+		return
+	}
+	f(e, message)
+}
+
+func (o *Comms) receiveAuthorization(e Envelope, message []byte) {
+	if e.Result != "ok" {
+		o.errLog.Println("authorization failed: ", string(message))
+		return
+	}
+	o.infoLog.Println("authorised: ", string(message))
+	o.setConnState(ConnAuthorized, 0, nil)
+	o.SubscribePrivate()
+}
+
+func (o *Comms) receiveUnauthorized(e Envelope, message []byte) {
+	o.errLog.Println("UNauthorised: ", string(message))
+	go o.reauthSocket()
+}
+
+func (o *Comms) receiveError(e Envelope, message []byte) {
+	//{"type":"error","message":"ping timeout"}
+	if e.Message == "ping timeout" {
+		o.dropSocket("server ping timeout")
+		return
+	}
+	o.errLog.Println("socket error:", e.Message)
+}
@@ -0,0 +1,65 @@
+package market
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// APIError is a ProBit error response: {"errorCode":"...","message":"...","details":{...}}.
+// Compare with the Err... sentinels through errors.Is.
+type APIError struct {
+	Code       string
+	Message    string
+	HTTPStatus int
+	Retryable  bool //the same request may succeed later: rate limit or server side failure
+}
+
+func (o *APIError) Error() string {
+	if o.Message == "" {
+		return fmt.Sprintf("probit: %s (http %d)", o.Code, o.HTTPStatus)
+	}
+	return fmt.Sprintf("probit: %s: %s (http %d)", o.Code, o.Message, o.HTTPStatus)
+}
+
+// Is matches sentinels by code, or by HTTP status for sentinels that carry one,
+// since 429 and 401 responses don't always come with a JSON body.
+func (o *APIError) Is(target error) bool {
+	t, ok := target.(*APIError)
+	if !ok {
+		return false
+	}
+	if t.Code != "" && t.Code == o.Code {
+		return true
+	}
+	return t.HTTPStatus != 0 && t.HTTPStatus == o.HTTPStatus
+}
+
+var (
+	ErrInsufficientBalance = &APIError{Code: "NOT_ENOUGH_BALANCE"}
+	ErrRateLimited         = &APIError{Code: "TOO_MANY_REQUESTS", HTTPStatus: http.StatusTooManyRequests}
+	ErrUnauthorized        = &APIError{Code: "UNAUTHORIZED", HTTPStatus: http.StatusUnauthorized}
+	ErrOrderNotFound       = &APIError{Code: "ORDER_NOT_FOUND"}
+	ErrInvalidArgument     = &APIError{Code: "INVALID_ARGUMENT"}
+)
+
+// checkAPIError returns an *APIError when the response is a failure, nil otherwise.
+func checkAPIError(resp *http.Response, body []byte) error {
+	var e struct {
+		ErrorCode string `json:"errorCode"`
+		Message   string `json:"message"`
+	}
+	json.Unmarshal(body, &e)
+	if resp.StatusCode < 300 && e.ErrorCode == "" {
+		return nil
+	}
+	a := APIError{Code: e.ErrorCode, Message: e.Message, HTTPStatus: resp.StatusCode}
+	if a.Code == "" {
+		a.Code = http.StatusText(resp.StatusCode)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			a.Code = ErrRateLimited.Code
+		}
+	}
+	a.Retryable = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return &a
+}
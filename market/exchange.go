@@ -0,0 +1,24 @@
+package market
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Exchange is what a MarketPair needs from a venue. Comms is the ProBit implementation;
+// other venues or test doubles only need to satisfy this interface.
+type Exchange interface {
+	GetMarketSpec(p string) (PairSpec, error)
+	NewOrder(r Order) (CurrentOrder, error)
+	CancelOrder(c CancelingOrder) (CurrentOrder, error)
+	GetMyOrdersPair(p string) ([]CurrentOrder, error)
+	GetBalanceAndAvail(co string) (decimal.Decimal, decimal.Decimal)
+	InvalidateBalances()
+	GetTradeHistory(p string, start time.Time, end time.Time) (*TradeHistory, error)
+	GetMarketOrdersHttp(p string) (*MarketOrders, error)
+	GetMarketTrades(p string, start time.Time, end time.Time) (*MarketTrades, error)
+	Subscribe(pair string)
+}
+
+var _ Exchange = (*Comms)(nil)
@@ -0,0 +1,94 @@
+package fakeprobit
+
+import (
+	"arbiter/market"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+// book keeps resting orders in matching order: best price first, then oldest first.
+type book struct {
+	bids []*restingOrder
+	asks []*restingOrder
+}
+
+func (o *book) insert(r *restingOrder) {
+	if r.Side == "buy" {
+		o.bids = append(o.bids, r)
+		sort.SliceStable(o.bids, func(i, j int) bool {
+			if !o.bids[i].price.Equal(o.bids[j].price) {
+				return o.bids[i].price.GreaterThan(o.bids[j].price)
+			}
+			return o.bids[i].seq < o.bids[j].seq
+		})
+		return
+	}
+	o.asks = append(o.asks, r)
+	sort.SliceStable(o.asks, func(i, j int) bool {
+		if !o.asks[i].price.Equal(o.asks[j].price) {
+			return o.asks[i].price.LessThan(o.asks[j].price)
+		}
+		return o.asks[i].seq < o.asks[j].seq
+	})
+}
+
+func (o *book) remove(r *restingOrder) {
+	side := &o.asks
+	if r.Side == "buy" {
+		side = &o.bids
+	}
+	for i, d := range *side {
+		if d == r {
+			*side = append((*side)[:i], (*side)[i+1:]...)
+			return
+		}
+	}
+}
+
+// levels aggregates resting quantity per side and price, the shape of /order_book.
+// With only set, it reports just those "side:price" keys, with "0" for emptied levels,
+// which is what the websocket diff packets carry.
+func (o *book) levels(only map[string]bool) []market.MarketOrder {
+	type level struct {
+		side  string
+		price decimal.Decimal
+		qty   decimal.Decimal
+	}
+	var keys []string
+	agg := map[string]*level{}
+	for _, side := range [][]*restingOrder{o.bids, o.asks} {
+		for _, r := range side {
+			k := r.Side + ":" + r.price.String()
+			if l, found := agg[k]; found {
+				l.qty = l.qty.Add(r.open)
+				continue
+			}
+			agg[k] = &level{side: r.Side, price: r.price, qty: r.open}
+			keys = append(keys, k)
+		}
+	}
+	data := []market.MarketOrder{}
+	for _, k := range keys {
+		if only == nil || only[k] {
+			l := agg[k]
+			data = append(data, market.MarketOrder{Side: l.side, Price: l.price.String(), Quantity: l.qty.String()})
+		}
+	}
+	for k := range only {
+		if _, found := agg[k]; !found {
+			side, price := splitKey(k)
+			data = append(data, market.MarketOrder{Side: side, Price: price, Quantity: "0"})
+		}
+	}
+	return data
+}
+
+func splitKey(k string) (string, string) {
+	for i := 0; i < len(k); i++ {
+		if k[i] == ':' {
+			return k[:i], k[i+1:]
+		}
+	}
+	return k, ""
+}
@@ -0,0 +1,571 @@
+// Package fakeprobit is an in-process stand-in for the ProBit REST and websocket API.
+// It keeps an in-memory order book per market and matches orders by price-time priority,
+// so market.Comms can be pointed at it (Server.CommsOptions) and the bot run offline.
+package fakeprobit
+
+import (
+	"arbiter/market"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	ownUser      = "own"
+	externalUser = "external"
+	tokenExpiry  = 900 //seconds, same as the real token endpoint
+)
+
+var errUnknownMarket = errors.New("fakeprobit: unknown market")
+
+type Server struct {
+	mu  sync.Mutex
+	srv *httptest.Server
+
+	specs    []market.PairSpec
+	books    map[string]*book
+	balances map[string]*balance
+	orders   map[string]*restingOrder //own orders by ID, open or not
+	history  []ownTrade
+	tape     map[string][]publicTrade
+	tokens   map[string]bool
+	nextID   int
+	rejects  map[string]string //error code of the next request, by endpoint
+
+	subs map[*wsClient]map[string]bool //subscribed market ids per socket
+}
+
+type balance struct {
+	total     decimal.Decimal
+	available decimal.Decimal
+}
+
+type restingOrder struct {
+	market.CurrentOrder
+	user     string
+	price    decimal.Decimal
+	open     decimal.Decimal
+	filled   decimal.Decimal
+	cost     decimal.Decimal
+	canceled decimal.Decimal
+	seq      int
+}
+
+type ownTrade struct {
+	ID            string    `json:"id"`
+	OrderID       string    `json:"order_id"`
+	Side          string    `json:"side"`
+	FeeAmount     string    `json:"fee_amount"`
+	FeeCurrencyID string    `json:"fee_currency_id"`
+	Status        string    `json:"status"`
+	Price         string    `json:"price"`
+	Quantity      string    `json:"quantity"`
+	Cost          string    `json:"cost"`
+	Time          time.Time `json:"time"`
+	MarketID      string    `json:"market_id"`
+}
+
+type publicTrade struct {
+	ID            string    `json:"id"`
+	Price         string    `json:"price"`
+	Quantity      string    `json:"quantity"`
+	Time          time.Time `json:"time"`
+	Side          string    `json:"side"`
+	TickDirection string    `json:"tick_direction"`
+}
+
+type apiError struct {
+	ErrorCode string            `json:"errorCode"`
+	Message   string            `json:"message"`
+	Details   map[string]string `json:"details"`
+}
+
+// New starts the server on a local port. Close it when done.
+func New() *Server {
+	s := &Server{
+		books:    make(map[string]*book),
+		balances: make(map[string]*balance),
+		orders:   make(map[string]*restingOrder),
+		tape:     make(map[string][]publicTrade),
+		tokens:   make(map[string]bool),
+		rejects:  make(map[string]string),
+		subs:     make(map[*wsClient]map[string]bool),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", s.handleToken)
+	mux.HandleFunc("/api/exchange/v1/market", s.handleMarket)
+	mux.HandleFunc("/api/exchange/v1/order_book", s.handleOrderBook)
+	mux.HandleFunc("/api/exchange/v1/trade", s.handleTrade)
+	mux.HandleFunc("/api/exchange/v1/open_order", s.private(s.handleOpenOrder))
+	mux.HandleFunc("/api/exchange/v1/new_order", s.private(s.handleNewOrder))
+	mux.HandleFunc("/api/exchange/v1/cancel_order", s.private(s.handleCancelOrder))
+	mux.HandleFunc("/api/exchange/v1/balance", s.private(s.handleBalance))
+	mux.HandleFunc("/api/exchange/v1/trade_history", s.private(s.handleTradeHistory))
+	mux.HandleFunc("/api/exchange/v1/ws", s.handleWebsocket)
+	s.srv = httptest.NewServer(mux)
+	return s
+}
+
+// URL is the base for both the REST and the token endpoints.
+func (o *Server) URL() string {
+	return o.srv.URL
+}
+
+func (o *Server) WebsocketURL() string {
+	return "ws" + strings.TrimPrefix(o.srv.URL, "http") + "/api/exchange/v1/ws"
+}
+
+// CommsOptions points a market.Comms at this server, with Credentials it accepts.
+func (o *Server) CommsOptions() market.CommsOptions {
+	return market.CommsOptions{RestURL: o.URL(), AuthURL: o.URL(), WsURL: o.WebsocketURL(), HTTPClient: o.srv.Client(),
+		Credentials: Credentials{}}
+}
+
+// Credentials is a fixed client id and secret; the server takes any.
+type Credentials struct{}
+
+func (Credentials) Credentials() (string, string, error) {
+	return "fake-id", "fake-secret", nil
+}
+
+func (o *Server) Close() {
+	o.mu.Lock()
+	for c := range o.subs {
+		c.conn.Close()
+	}
+	o.mu.Unlock()
+	o.srv.Close()
+}
+
+// AddMarket lists a pair in /market and opens an empty book for it.
+func (o *Server) AddMarket(s market.PairSpec) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.specs = append(o.specs, s)
+	o.books[s.ID] = &book{}
+}
+
+// AddDemoMarket lists pair with a spec scaled to mid, puts external orders on five
+// levels per side around it and funds the own account with both currencies, so a
+// bot can trade the pair right away.
+func (o *Server) AddDemoMarket(pair string, mid decimal.Decimal) error {
+	split := strings.Split(pair, "-")
+	if len(split) != 2 || !mid.IsPositive() {
+		return errors.New("fakeprobit: demo market needs a BASE-QUOTE pair and a positive price")
+	}
+	mf, _ := mid.Float64()
+	inc := decimal.New(1, int32(math.Floor(math.Log10(mf)))-4)
+	o.AddMarket(market.PairSpec{ID: pair, BaseCurrencyID: split[0], QuoteCurrencyID: split[1],
+		MinPrice: inc.String(), MaxPrice: mid.Mul(decimal.NewFromInt(1000)).String(), PriceIncrement: inc.String(),
+		MinQuantity: "0.0001", MaxQuantity: "1000000000", QuantityPrecision: 4, MinCost: "1",
+		TakerFeeRate: "0.2", MakerFeeRate: "0.2"})
+	quantity := decimal.NewFromInt(1000).DivRound(mid, 4)
+	for i := int64(1); i <= 5; i++ {
+		step := mid.Mul(decimal.New(i, -3)).Div(inc).Round(0).Mul(inc)
+		o.PlaceExternal(pair, "buy", mid.Sub(step), quantity)
+		o.PlaceExternal(pair, "sell", mid.Add(step), quantity)
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if b := o.balance(split[0]); b.total.IsZero() {
+		b.total, b.available = quantity.Mul(decimal.NewFromInt(100)), quantity.Mul(decimal.NewFromInt(100))
+	}
+	if b := o.balance(split[1]); b.total.IsZero() {
+		b.total, b.available = decimal.NewFromInt(100000), decimal.NewFromInt(100000)
+	}
+	return nil
+}
+
+// Reject fails the next private request to endpoint, the last element of its path
+// (e.g. "cancel_order"), with HTTP 400 and code, without handling it.
+func (o *Server) Reject(endpoint string, code string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.rejects[endpoint] = code
+}
+
+// SetBalance sets the total and available amount of a currency of the own account.
+func (o *Server) SetBalance(currency string, amount decimal.Decimal) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.balances[currency] = &balance{total: amount, available: amount}
+}
+
+// Balance returns the own account's total and available amount of a currency.
+func (o *Server) Balance(currency string) (decimal.Decimal, decimal.Decimal) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	b := o.balance(currency)
+	return b.total, b.available
+}
+
+// PlaceExternal puts a limit order from another participant into the book.
+// It matches against resting orders (including own ones) before resting itself.
+func (o *Server) PlaceExternal(marketID string, side string, price decimal.Decimal, quantity decimal.Decimal) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	bk, found := o.books[marketID]
+	if !found {
+		return errUnknownMarket
+	}
+	r := o.newResting(externalUser, market.Order{MarketID: marketID, Side: side, Type: "limit", TimeInForce: "gtc",
+		LimitPrice: price.String(), Quantity: quantity.String()}, price, quantity)
+	o.match(bk, r)
+	return nil
+}
+
+func (o *Server) balance(currency string) *balance {
+	b, found := o.balances[currency]
+	if !found {
+		b = &balance{}
+		o.balances[currency] = b
+	}
+	return b
+}
+
+func (o *Server) spec(marketID string) (market.PairSpec, bool) {
+	for _, s := range o.specs {
+		if s.ID == marketID {
+			return s, true
+		}
+	}
+	return market.PairSpec{}, false
+}
+
+func (o *Server) newID() string {
+	o.nextID++
+	return strconv.Itoa(o.nextID)
+}
+
+func (o *Server) newResting(user string, r market.Order, price decimal.Decimal, quantity decimal.Decimal) *restingOrder {
+	id := o.newID()
+	return &restingOrder{
+		CurrentOrder: market.CurrentOrder{ID: id, UserID: user, MarketID: r.MarketID, Type: r.Type, Side: r.Side,
+			Quantity: quantity.String(), LimitPrice: price.String(), TimeInForce: r.TimeInForce,
+			Time: time.Now().UTC(), ClientOrderID: r.ClientOrderID},
+		user: user, price: price, open: quantity, seq: o.nextID,
+	}
+}
+
+func (o *restingOrder) view() market.CurrentOrder {
+	c := o.CurrentOrder
+	c.FilledQuantity = o.filled.String()
+	c.FilledCost = o.cost.String()
+	c.OpenQuantity = o.open.String()
+	c.CancelledQuantity = o.canceled.String()
+	switch {
+	case o.open.IsPositive():
+		c.Status = "open"
+	case o.canceled.IsPositive():
+		c.Status = "cancelled"
+	default:
+		c.Status = "filled"
+	}
+	return c
+}
+
+// match crosses r against the opposite side of bk, settles own fills and rests the remainder.
+// Changed price levels are pushed to websocket subscribers.
+func (o *Server) match(bk *book, r *restingOrder) {
+	changed := map[string]bool{}
+	var trades []publicTrade
+	opposite := &bk.asks
+	if r.Side == "sell" {
+		opposite = &bk.bids
+	}
+	for r.open.IsPositive() && len(*opposite) > 0 {
+		top := (*opposite)[0]
+		if (r.Side == "buy" && top.price.GreaterThan(r.price)) || (r.Side == "sell" && top.price.LessThan(r.price)) {
+			break
+		}
+		q := decimal.Min(r.open, top.open)
+		o.fill(top, q, top.price)
+		o.fill(r, q, top.price)
+		t := publicTrade{ID: o.newID(), Price: top.price.String(), Quantity: q.String(), Time: time.Now().UTC(),
+			Side: r.Side, TickDirection: "zero"}
+		o.tape[r.MarketID] = append(o.tape[r.MarketID], t)
+		trades = append(trades, t)
+		changed[top.Side+":"+top.price.String()] = true
+		if !top.open.IsPositive() {
+			*opposite = (*opposite)[1:]
+		}
+	}
+	if r.open.IsPositive() {
+		bk.insert(r)
+		changed[r.Side+":"+r.price.String()] = true
+	}
+	o.publishDiff(r.MarketID, bk, changed, trades)
+}
+
+// fill moves q at price p out of the order and, for own orders, settles the balances.
+func (o *Server) fill(r *restingOrder, q decimal.Decimal, p decimal.Decimal) {
+	r.open = r.open.Sub(q)
+	r.filled = r.filled.Add(q)
+	r.cost = r.cost.Add(q.Mul(p))
+	if r.user != ownUser {
+		return
+	}
+	s, _ := o.spec(r.MarketID)
+	base, quote := o.balance(s.BaseCurrencyID), o.balance(s.QuoteCurrencyID)
+	cost := q.Mul(p)
+	if r.Side == "buy" {
+		//quote was reserved at the limit price; refund the price improvement
+		quote.total = quote.total.Sub(cost)
+		quote.available = quote.available.Add(q.Mul(r.price).Sub(cost))
+		base.total = base.total.Add(q)
+		base.available = base.available.Add(q)
+	} else {
+		base.total = base.total.Sub(q)
+		quote.total = quote.total.Add(cost)
+		quote.available = quote.available.Add(cost)
+	}
+	t := ownTrade{ID: o.newID(), OrderID: r.ID, Side: r.Side, FeeAmount: "0",
+		FeeCurrencyID: s.QuoteCurrencyID, Status: "settled", Price: p.String(), Quantity: q.String(),
+		Cost: cost.String(), Time: time.Now().UTC(), MarketID: r.MarketID}
+	o.history = append(o.history, t)
+	o.publishPrivate("trade_history", []ownTrade{t})
+	o.pushOrder(r)
+	o.pushBalance(s.BaseCurrencyID, s.QuoteCurrencyID)
+}
+
+func (o *Server) cancel(r *restingOrder) {
+	bk := o.books[r.MarketID]
+	bk.remove(r)
+	s, _ := o.spec(r.MarketID)
+	if r.Side == "buy" {
+		q := o.balance(s.QuoteCurrencyID)
+		q.available = q.available.Add(r.open.Mul(r.price))
+	} else {
+		b := o.balance(s.BaseCurrencyID)
+		b.available = b.available.Add(r.open)
+	}
+	r.canceled = r.canceled.Add(r.open)
+	r.open = decimal.Zero
+	o.publishDiff(r.MarketID, bk, map[string]bool{r.Side + ":" + r.price.String(): true}, nil)
+	o.pushOrder(r)
+	o.pushBalance(s.BaseCurrencyID, s.QuoteCurrencyID)
+}
+
+// ///////////////////////////////////HTTP handlers
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, msg string) {
+	writeJSON(w, status, apiError{ErrorCode: code, Message: msg, Details: map[string]string{}})
+}
+
+func (o *Server) private(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tok := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		endpoint := path.Base(r.URL.Path)
+		o.mu.Lock()
+		ok := o.tokens[tok]
+		code, reject := o.rejects[endpoint]
+		if ok && reject {
+			delete(o.rejects, endpoint)
+		}
+		o.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid or missing token")
+			return
+		}
+		if reject {
+			writeError(w, http.StatusBadRequest, code, "rejected by the test")
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (o *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Authorization"), "Basic ") {
+		writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "basic auth required")
+		return
+	}
+	o.mu.Lock()
+	tok := "fake-token-" + o.newID()
+	o.tokens[tok] = true
+	o.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"access_token": tok, "token_type": "bearer", "expires_in": tokenExpiry})
+}
+
+func (o *Server) handleMarket(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": o.specs})
+}
+
+func (o *Server) handleOrderBook(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	bk, found := o.books[r.URL.Query().Get("market_id")]
+	if !found {
+		writeError(w, http.StatusBadRequest, "INVALID_MARKET", "unknown market_id")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": bk.levels(nil)})
+}
+
+func (o *Server) handleTrade(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	q := r.URL.Query()
+	start, end := parseRange(q.Get("start_time"), q.Get("end_time"))
+	data := []publicTrade{}
+	for _, t := range o.tape[q.Get("market_id")] {
+		if !t.Time.Before(start) && !t.Time.After(end) {
+			data = append(data, t)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func (o *Server) handleOpenOrder(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p := r.URL.Query().Get("market_id")
+	data := []market.CurrentOrder{}
+	for _, d := range o.sortedOrders() {
+		if d.open.IsPositive() && (p == "" || d.MarketID == p) {
+			data = append(data, d.view())
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func (o *Server) sortedOrders() []*restingOrder {
+	l := make([]*restingOrder, 0, len(o.orders))
+	for _, d := range o.orders {
+		l = append(l, d)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].seq < l[j].seq })
+	return l
+}
+
+func (o *Server) handleNewOrder(w http.ResponseWriter, r *http.Request) {
+	var req market.Order
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	s, found := o.spec(req.MarketID)
+	if !found {
+		writeError(w, http.StatusBadRequest, "INVALID_MARKET", "unknown market_id")
+		return
+	}
+	if req.Type != "limit" || (req.Side != "buy" && req.Side != "sell") {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "only buy/sell limit orders are supported")
+		return
+	}
+	price, e1 := decimal.NewFromString(req.LimitPrice)
+	qty, e2 := decimal.NewFromString(req.Quantity)
+	if e1 != nil || e2 != nil || !price.IsPositive() || !qty.IsPositive() {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "bad limit_price or quantity")
+		return
+	}
+	if minQ, err := decimal.NewFromString(s.MinQuantity); err == nil && qty.LessThan(minQ) {
+		writeError(w, http.StatusBadRequest, "TOO_SMALL_QUANTITY", "quantity below min_quantity")
+		return
+	}
+	reserve, currency := qty, s.BaseCurrencyID
+	if req.Side == "buy" {
+		reserve, currency = qty.Mul(price), s.QuoteCurrencyID
+	}
+	b := o.balance(currency)
+	if b.available.LessThan(reserve) {
+		writeError(w, http.StatusBadRequest, "NOT_ENOUGH_BALANCE", "not enough balance")
+		return
+	}
+	b.available = b.available.Sub(reserve)
+	d := o.newResting(ownUser, req, price, qty)
+	o.orders[d.ID] = d
+	o.pushBalance(currency)
+	o.match(o.books[req.MarketID], d)
+	if d.open.Equal(qty) {
+		o.pushOrder(d) //fills already reported it
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": d.view()})
+}
+
+func (o *Server) handleCancelOrder(w http.ResponseWriter, r *http.Request) {
+	var req market.CancelingOrder
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	d, found := o.orders[req.OrderID]
+	if !found || d.MarketID != req.MarketID {
+		writeError(w, http.StatusBadRequest, "ORDER_NOT_FOUND", "order not found")
+		return
+	}
+	if !d.open.IsPositive() {
+		writeError(w, http.StatusBadRequest, "ORDER_NOT_FOUND", "order is not open")
+		return
+	}
+	o.cancel(d)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": d.view()})
+}
+
+func (o *Server) handleBalance(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	type entry struct {
+		CurrencyID string `json:"currency_id"`
+		Total      string `json:"total"`
+		Available  string `json:"available"`
+	}
+	ids := make([]string, 0, len(o.balances))
+	for c := range o.balances {
+		ids = append(ids, c)
+	}
+	sort.Strings(ids)
+	data := []entry{}
+	for _, c := range ids {
+		data = append(data, entry{CurrencyID: c, Total: o.balances[c].total.String(), Available: o.balances[c].available.String()})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func (o *Server) handleTradeHistory(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	q := r.URL.Query()
+	start, end := parseRange(q.Get("start_time"), q.Get("end_time"))
+	data := []ownTrade{}
+	for _, t := range o.history {
+		if t.MarketID == q.Get("market_id") && !t.Time.Before(start) && !t.Time.After(end) {
+			data = append(data, t)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func parseRange(start string, end string) (time.Time, time.Time) {
+	s, err := time.Parse(time.RFC3339, start)
+	if err != nil {
+		s = time.Time{}
+	}
+	e, err := time.Parse(time.RFC3339, end)
+	if err != nil {
+		e = time.Now()
+	}
+	//Comms sends whole seconds; keep trades of the current second
+	return s, e.Add(time.Second)
+}
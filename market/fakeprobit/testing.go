@@ -0,0 +1,52 @@
+package fakeprobit
+
+import (
+	"arbiter/market"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+)
+
+var quiet = log.New(ioutil.Discard, "", 0)
+
+// NewTestComms starts a server and an authorized Comms on it for a test, both closed
+// when the test ends. setup lists the markets and funds the account before the Comms
+// loads the specs. The logs are discarded, the reconnect delays shortened and the
+// rate budgets raised, as the server has no request limits.
+func NewTestComms(tb testing.TB, setup func(s *Server) error) (*Server, *market.Comms) {
+	tb.Helper()
+	s := New()
+	tb.Cleanup(s.Close)
+	if setup != nil {
+		if err := setup(s); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	opts := s.CommsOptions()
+	opts.ReconnectMin = 50 * time.Millisecond
+	opts.ReconnectMax = 200 * time.Millisecond
+	opts.RateBudgets = make(map[market.EndpointClass]market.RateBudget)
+	for _, class := range []market.EndpointClass{market.ClassPublic, market.ClassOrder, market.ClassAccount, market.ClassAuth} {
+		opts.RateBudgets[class] = market.RateBudget{PerSecond: 1000, Burst: 1000}
+	}
+	c := market.NewComms(opts, quiet, quiet, quiet)
+	if err := c.FetchAllMarketSpecs(); err != nil {
+		tb.Fatal(err)
+	}
+	c.StartAuth()
+	return s, c
+}
+
+// NewTestPair makes a MarketPair of a listed pair with the logs discarded, closed
+// when the test ends. It is not registered with c.
+func NewTestPair(tb testing.TB, c *market.Comms, pair string, st market.Strategy) *market.MarketPair {
+	tb.Helper()
+	sp, err := c.GetMarketSpec(pair)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	m := market.NewMarketPair(pair, c, sp, st, quiet, quiet, quiet)
+	tb.Cleanup(m.Close)
+	return m
+}
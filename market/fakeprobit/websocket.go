@@ -0,0 +1,253 @@
+package fakeprobit
+
+import (
+	"arbiter/market"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+)
+
+type wsClient struct {
+	conn       *websocket.Conn
+	sendMu     sync.Mutex
+	authorized bool
+	private    map[string]bool //subscribed private channels
+}
+
+func (o *wsClient) send(v interface{}) {
+	b, _ := json.Marshal(v)
+	o.sendMu.Lock()
+	o.conn.WriteMessage(websocket.TextMessage, b)
+	o.sendMu.Unlock()
+}
+
+// wsReply is a command reply; Comms routes it by errorCode, then by type (see market.Envelope).
+type wsReply struct {
+	Type      string `json:"type"`
+	Result    string `json:"result,omitempty"`
+	ErrorCode string `json:"errorCode,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+type wsCommand struct {
+	Type     string   `json:"type"`
+	Token    string   `json:"token"`
+	Channel  string   `json:"channel"`
+	MarketID string   `json:"market_id"`
+	Filter   []string `json:"filter"`
+}
+
+type ticker struct {
+	Time        time.Time `json:"time"`
+	Last        string    `json:"last"`
+	Low         string    `json:"low"`
+	High        string    `json:"high"`
+	Change      string    `json:"change"`
+	BaseVolume  string    `json:"base_volume"`
+	QuoteVolume string    `json:"quote_volume"`
+}
+
+type marketDataMsg struct {
+	Channel      string               `json:"channel"`
+	MarketID     string               `json:"market_id"`
+	Status       string               `json:"status"`
+	Lag          int                  `json:"lag"`
+	Ticker       *ticker              `json:"ticker,omitempty"`
+	OrderBooks   []market.MarketOrder `json:"order_books"`
+	RecentTrades []publicTrade        `json:"recent_trades,omitempty"`
+	Reset        bool                 `json:"reset"`
+}
+
+// recentTrades is the snapshot of the recent_trades filter.
+const recentTrades = 100
+
+var upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+
+func (o *Server) handleWebsocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &wsClient{conn: conn, private: map[string]bool{}}
+	o.mu.Lock()
+	o.subs[c] = map[string]bool{}
+	o.mu.Unlock()
+	defer func() {
+		o.mu.Lock()
+		delete(o.subs, c)
+		o.mu.Unlock()
+		conn.Close()
+	}()
+	for {
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var cmd wsCommand
+		if json.Unmarshal(b, &cmd) != nil {
+			c.send(wsReply{Type: "error", Message: "invalid json"})
+			continue
+		}
+		o.handleCommand(c, cmd)
+	}
+}
+
+// DropConnections closes every websocket connection without a close frame, like a
+// network failure. Clients have to reconnect and subscribe again.
+func (o *Server) DropConnections() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for c := range o.subs {
+		c.conn.Close()
+	}
+}
+
+func (o *Server) handleCommand(c *wsClient, cmd wsCommand) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch {
+	case cmd.Type == "authorization":
+		if !o.tokens[cmd.Token] {
+			c.send(wsReply{Type: "authorization", Result: "error", ErrorCode: "UNAUTHORIZED"})
+			return
+		}
+		c.authorized = true
+		c.send(wsReply{Type: "authorization", Result: "ok"})
+	case cmd.Type == "subscribe" && cmd.Channel == "marketdata":
+		bk, found := o.books[cmd.MarketID]
+		if !found {
+			c.send(wsReply{Type: "error", Message: "unknown market_id"})
+			return
+		}
+		o.subs[c][cmd.MarketID] = true
+		t := o.ticker(cmd.MarketID)
+		tape := o.tape[cmd.MarketID]
+		if len(tape) > recentTrades {
+			tape = tape[len(tape)-recentTrades:]
+		}
+		c.send(marketDataMsg{Channel: "marketdata", MarketID: cmd.MarketID, Status: "ok", Ticker: &t,
+			OrderBooks: bk.levels(nil), RecentTrades: tape, Reset: true})
+	case cmd.Type == "unsubscribe" && cmd.Channel == "marketdata":
+		delete(o.subs[c], cmd.MarketID)
+	case cmd.Type == "subscribe" && privateChannel(cmd.Channel):
+		if !c.authorized {
+			c.send(wsReply{Type: "subscribe", Result: "error", ErrorCode: "UNAUTHORIZED"})
+			return
+		}
+		c.private[cmd.Channel] = true
+		c.send(o.privateSnapshot(cmd.Channel))
+	case cmd.Type == "unsubscribe" && privateChannel(cmd.Channel):
+		delete(c.private, cmd.Channel)
+	default:
+		c.send(wsReply{Type: "error", Message: "unsupported command"})
+	}
+}
+
+// publishDiff sends the changed levels of a book and the new trades to every socket subscribed to it.
+func (o *Server) publishDiff(marketID string, bk *book, changed map[string]bool, trades []publicTrade) {
+	if len(changed) == 0 && len(trades) == 0 {
+		return
+	}
+	msg := marketDataMsg{Channel: "marketdata", MarketID: marketID, Status: "ok", OrderBooks: bk.levels(changed), RecentTrades: trades}
+	for c, markets := range o.subs {
+		if markets[marketID] {
+			c.send(msg)
+		}
+	}
+}
+
+// ticker summarises the last 24h of the public trade tape.
+func (o *Server) ticker(marketID string) ticker {
+	t := ticker{Time: time.Now().UTC()}
+	var first, last, low, high, base, quote decimal.Decimal
+	n := 0
+	for _, d := range o.tape[marketID] {
+		if d.Time.Before(time.Now().Add(-24 * time.Hour)) {
+			continue
+		}
+		p, _ := decimal.NewFromString(d.Price)
+		q, _ := decimal.NewFromString(d.Quantity)
+		if n == 0 {
+			first, low, high = p, p, p
+		}
+		low, high = decimal.Min(low, p), decimal.Max(high, p)
+		last = p
+		base = base.Add(q)
+		quote = quote.Add(q.Mul(p))
+		n++
+	}
+	t.Last, t.Low, t.High = last.String(), low.String(), high.String()
+	t.Change = last.Sub(first).String()
+	t.BaseVolume, t.QuoteVolume = base.String(), quote.String()
+	return t
+}
+
+type privateMsg struct {
+	Channel string      `json:"channel"`
+	Reset   bool        `json:"reset"`
+	Data    interface{} `json:"data"`
+}
+
+type balanceEntry struct {
+	Available string `json:"available"`
+	Total     string `json:"total"`
+}
+
+func privateChannel(ch string) bool {
+	return ch == "open_order" || ch == "order_history" || ch == "trade_history" || ch == "balance"
+}
+
+func (o *Server) privateSnapshot(ch string) privateMsg {
+	m := privateMsg{Channel: ch, Reset: true}
+	switch ch {
+	case "open_order":
+		data := []market.CurrentOrder{}
+		for _, d := range o.sortedOrders() {
+			if d.open.IsPositive() {
+				data = append(data, d.view())
+			}
+		}
+		m.Data = data
+	case "order_history":
+		m.Data = []market.CurrentOrder{}
+	case "trade_history":
+		m.Data = append([]ownTrade{}, o.history...)
+	case "balance":
+		data := map[string]balanceEntry{}
+		for c, b := range o.balances {
+			data[c] = balanceEntry{Available: b.available.String(), Total: b.total.String()}
+		}
+		m.Data = data
+	}
+	return m
+}
+
+func (o *Server) publishPrivate(ch string, data interface{}) {
+	for c := range o.subs {
+		if c.private[ch] {
+			c.send(privateMsg{Channel: ch, Data: data})
+		}
+	}
+}
+
+// pushOrder reports an own order change on open_order, and on order_history once closed.
+func (o *Server) pushOrder(r *restingOrder) {
+	v := r.view()
+	o.publishPrivate("open_order", []market.CurrentOrder{v})
+	if v.Status != "open" {
+		o.publishPrivate("order_history", []market.CurrentOrder{v})
+	}
+}
+
+func (o *Server) pushBalance(currencies ...string) {
+	data := map[string]balanceEntry{}
+	for _, c := range currencies {
+		b := o.balance(c)
+		data[c] = balanceEntry{Available: b.available.String(), Total: b.total.String()}
+	}
+	o.publishPrivate("balance", data)
+}
@@ -0,0 +1,67 @@
+package market
+
+import (
+	"sort"
+	"time"
+)
+
+//Own fills reach Strategy.OnFill from the trade_history channel or, while the private
+//channels don't push orders, from /trade_history when UpdateMyOrders sees an order
+//filled. Both go through passFills, which drops fills already passed by ID, so a
+//fill seen on both ways is counted once.
+
+// fillsOverlap is how far before the newest passed fill /trade_history is read
+// again; its times are in seconds.
+const fillsOverlap = time.Minute
+
+// passFills gives the fills not passed before to OnFill.
+func (o *MarketPair) passFills(trades []MyTrade) {
+	if o.fillsSeen == nil {
+		o.fillsSeen = make(map[string]time.Time)
+	}
+	var fresh []MyTrade
+	for _, t := range trades {
+		if _, seen := o.fillsSeen[t.ID]; seen {
+			continue
+		}
+		o.fillsSeen[t.ID] = t.Time
+		if t.Time.After(o.fillsSince) {
+			o.fillsSince = t.Time
+		}
+		fresh = append(fresh, t)
+	}
+	for id, at := range o.fillsSeen {
+		if at.Before(o.fillsSince.Add(-2 * fillsOverlap)) {
+			delete(o.fillsSeen, id)
+		}
+	}
+	if len(fresh) == 0 {
+		return
+	}
+	for _, t := range fresh {
+		o.warnLog.Println(o.pair, "filled:", t.Side, t.Quantity, "at", t.Price, "order:", t.OrderID)
+	}
+	o.strategy.OnFill(o, fresh)
+}
+
+// loadFills passes the fills of the pair since the newest one passed, or since the
+// pair's start, read from /trade_history.
+func (o *MarketPair) loadFills() {
+	since := o.fillsSince
+	if since.Before(o.startTime) {
+		since = o.startTime
+	}
+	h, err := o.comms.GetTradeHistory(o.pair, since.Add(-fillsOverlap), time.Now().Add(time.Minute))
+	if err != nil {
+		o.errLog.Println(o.pair, "fills not loaded:", err)
+		return
+	}
+	var l []MyTrade
+	for _, t := range h.Data {
+		if t.MarketID == o.pair && !t.Time.Before(o.startTime) {
+			l = append(l, t)
+		}
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Time.Before(l[j].Time) })
+	o.passFills(l)
+}
@@ -0,0 +1,189 @@
+package market
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+//Concurrency model of MarketPair. All its state is owned by one goroutine per pair:
+//Comms posts websocket packets, own order and fill pushes and socket losses to the
+//pair's event queue, and the loop applies them one at a time, then calls the Strategy
+//hooks on the same goroutine. The hooks, and the MarketPair methods they call, need no
+//locking. Other goroutines run code on the loop with Do, or read Snapshot, an
+//immutable copy of the state published after every event.
+
+// eventQueue is the backlog of a pair's event queue above which the pair warns
+// that its loop falls behind. The queue itself is unbounded: events are posted from
+// other pairs' loops too (a fill's balance change is pushed to every pair of the
+// coin), so a full queue blocking its poster could deadlock two pairs.
+const eventQueue = 1024
+
+// SnapshotDepth is the number of levels per side copied into a Snapshot.
+const SnapshotDepth = 20
+
+type pairLoop struct {
+	mu       sync.Mutex
+	events   []func()
+	behind   bool          //the backlog was above eventQueue
+	wake     chan struct{} //signalled when events is no longer empty
+	started  sync.Once
+	done     chan struct{}
+	closed   sync.Once
+	snap     atomic.Value  //*Snapshot
+	interval time.Duration //of OnTimer, only changed on the loop
+}
+
+func newPairLoop() *pairLoop {
+	return &pairLoop{wake: make(chan struct{}, 1), done: make(chan struct{}), interval: DefaultTimerInterval}
+}
+
+// Snapshot is a copy of a pair's market and own orders; it is never changed after
+// it is published, so it can be read from any goroutine.
+type Snapshot struct {
+	Pair         string
+	Time         time.Time
+	Bids         []PriceLevel //best first
+	Asks         []PriceLevel //best first
+	MyOrders     []CurrentOrder
+	MyHighestBuy PriceLevel
+	MyLowestSell PriceLevel
+	Ticker       TickerStats
+	Trusted      bool //the book passed the consistency checks, see BookTrusted
+}
+
+// Spread is the best ask minus the best bid; false if a side is empty.
+func (o *Snapshot) Spread() (decimal.Decimal, bool) {
+	if len(o.Bids) == 0 || len(o.Asks) == 0 {
+		return decimal.Zero, false
+	}
+	return o.Asks[0].Price.Sub(o.Bids[0].Price), true
+}
+
+// post queues f to run on the pair's goroutine, starting it on first use. It never
+// blocks, so it can be called from any pair's loop. f is dropped if the pair is closed.
+func (o *MarketPair) post(f func()) {
+	o.loop.started.Do(func() { go o.run() })
+	select {
+	case <-o.loop.done:
+		return
+	default:
+	}
+	o.loop.mu.Lock()
+	o.loop.events = append(o.loop.events, f)
+	n := len(o.loop.events)
+	warn := n > eventQueue && !o.loop.behind
+	if warn {
+		o.loop.behind = true
+	}
+	o.loop.mu.Unlock()
+	if warn {
+		o.warnLog.Println(o.pair, "event queue behind:", n, "events")
+	}
+	select {
+	case o.loop.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next takes the oldest queued event; false if there is none.
+func (o *pairLoop) next() (func(), bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.events) == 0 {
+		o.behind = false
+		return nil, false
+	}
+	f := o.events[0]
+	o.events[0] = nil
+	o.events = o.events[1:]
+	return f, true
+}
+
+func (o *MarketPair) run() {
+	var timer *time.Ticker
+	var tick <-chan time.Time
+	var interval time.Duration
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		if interval != o.loop.interval {
+			interval = o.loop.interval
+			if timer != nil {
+				timer.Stop()
+				timer, tick = nil, nil
+			}
+			if interval > 0 {
+				timer = time.NewTicker(interval)
+				tick = timer.C
+			}
+		}
+		select {
+		case <-o.loop.wake:
+			for {
+				f, ok := o.loop.next()
+				if !ok {
+					break
+				}
+				f()
+				o.publish()
+				select {
+				case <-o.loop.done:
+					return
+				default:
+				}
+			}
+		case t := <-tick:
+			o.strategy.OnTimer(o, t)
+			o.publish()
+		case <-o.loop.done:
+			return
+		}
+	}
+}
+
+// Do runs f on the pair's goroutine and waits for it. It must not be called from
+// a Strategy hook, which already runs there and can use the pair directly.
+func (o *MarketPair) Do(f func(m *MarketPair)) {
+	ran := make(chan struct{})
+	o.post(func() {
+		f(o)
+		close(ran)
+	})
+	select {
+	case <-ran:
+	case <-o.loop.done:
+	}
+}
+
+// Close stops the pair's goroutine; events posted afterwards are dropped.
+// Unregister the pair from Comms first.
+func (o *MarketPair) Close() {
+	o.loop.closed.Do(func() { close(o.loop.done) })
+}
+
+// Snapshot is the state after the last event; nil before the first one.
+func (o *MarketPair) Snapshot() *Snapshot {
+	s, _ := o.loop.snap.Load().(*Snapshot)
+	return s
+}
+
+func (o *MarketPair) publish() {
+	s := Snapshot{
+		Pair:         o.pair,
+		Time:         time.Now(),
+		Bids:         o.Book.Top("buy", SnapshotDepth),
+		Asks:         o.Book.Top("sell", SnapshotDepth),
+		MyOrders:     append([]CurrentOrder{}, o.MyOrders...),
+		MyHighestBuy: PriceLevel(o.MyHighestBuy),
+		MyLowestSell: PriceLevel(o.MyLowestSell),
+		Ticker:       o.ticker,
+		Trusted:      o.haveBook && o.bookTrusted,
+	}
+	o.loop.snap.Store(&s)
+}
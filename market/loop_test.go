@@ -0,0 +1,146 @@
+package market_test
+
+import (
+	"arbiter/market"
+	"arbiter/market/fakeprobit"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var loopPairs = []string{"BTC-USDT", "ETH-USDT", "LTC-USDT"}
+
+// Pairs posting to each other from their loops, more than the queue's nominal
+// capacity, must not block one another.
+func TestPostFromLoopDoesNotBlock(t *testing.T) {
+	_, c := newFake(t)
+	a := fakeprobit.NewTestPair(t, c, testPair, market.BaseStrategy{})
+	b := fakeprobit.NewTestPair(t, c, testPair, market.BaseStrategy{})
+	flood := func(from *market.MarketPair, to *market.MarketPair, done chan<- struct{}) {
+		from.Do(func(m *market.MarketPair) {
+			for i := 0; i < 3000; i++ {
+				to.PushMyOrders(nil, false)
+				m.PushMyOrders(nil, false)
+			}
+		})
+		close(done)
+	}
+	da, db := make(chan struct{}), make(chan struct{})
+	go flood(a, b, da)
+	go flood(b, a, db)
+	for _, done := range []chan struct{}{da, db} {
+		select {
+		case <-done:
+		case <-time.After(waitTimeout):
+			t.Fatal("posting from a pair loop blocked")
+		}
+	}
+	//both queues drain
+	a.Do(func(m *market.MarketPair) {})
+	b.Do(func(m *market.MarketPair) {})
+}
+
+// TestPairsRace drives several pairs through the fake exchange while other
+// goroutines use every entry point of MarketPair; run it with -race.
+func TestPairsRace(t *testing.T) {
+	f, c := newFake(t)
+	for _, p := range loopPairs[1:] {
+		if err := f.AddDemoMarket(p, decimal.NewFromInt(100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.FetchAllMarketSpecs(); err != nil {
+		t.Fatal(err)
+	}
+
+	var hooks int64
+	pairs := make(map[string]*market.MarketPair)
+	for _, p := range loopPairs {
+		p := p
+		//the hooks place and cancel orders, so balance changes are posted from one
+		//pair's loop to every pair of the quote currency
+		m := fakeprobit.NewTestPair(t, c, p, market.FuncStrategy(func(m *market.MarketPair) {
+			n := atomic.AddInt64(&hooks, 1)
+			if n%10 == 0 && m.MyHighestBuy.Price.IsZero() && !m.MarketHighestBuy.Price.IsZero() {
+				m.NewOrder(market.Order{MarketID: p, Type: "limit", Side: "buy", TimeInForce: "gtc",
+					LimitPrice: m.MarketHighestBuy.Price.String(), Quantity: "0.1"})
+			}
+			if n%17 == 0 {
+				m.CancelOrders("buy")
+			}
+		}))
+		m.SetTimerInterval(20 * time.Millisecond)
+		if err := c.AddPair(p, m); err != nil {
+			t.Fatal(err)
+		}
+		pairs[p] = m
+	}
+	openSocket(t, c)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	run := func(every time.Duration, f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-time.After(every):
+					f()
+				}
+			}
+		}()
+	}
+	run(time.Millisecond, func() { //external order flow crossing the own orders
+		p := loopPairs[rand.Intn(len(loopPairs))]
+		side := []string{"buy", "sell"}[rand.Intn(2)]
+		f.PlaceExternal(p, side, decimal.New(int64(9950+rand.Intn(100)), -2), decimal.New(5, -2))
+	})
+	run(400*time.Millisecond, f.DropConnections)
+	for _, m := range pairs {
+		m := m
+		run(5*time.Millisecond, func() {
+			if s := m.Snapshot(); s != nil {
+				s.Spread()
+			}
+		})
+		run(20*time.Millisecond, func() { m.Do(func(m *market.MarketPair) { m.UpdateMarketHttp() }) })
+		run(30*time.Millisecond, func() {
+			if s := m.Snapshot(); s != nil {
+				m.PushMyOrders(s.MyOrders, false)
+			}
+		})
+		run(50*time.Millisecond, func() { m.BackfillTape(time.Now().Add(-time.Minute)) })
+		run(50*time.Millisecond, func() { m.Tape.Stats(time.Minute) })
+	}
+	run(10*time.Millisecond, func() {
+		c.Balances().All()
+		c.Subscriptions()
+		c.RXStats()
+	})
+	time.Sleep(2 * time.Second)
+	close(stop)
+	wg.Wait()
+
+	if atomic.LoadInt64(&hooks) == 0 {
+		t.Error("no strategy hook ran")
+	}
+	for p, m := range pairs {
+		done := make(chan struct{})
+		go func() {
+			m.Do(func(m *market.MarketPair) {})
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(waitTimeout):
+			t.Fatal(p, "loop stuck")
+		}
+	}
+}
@@ -1,16 +1,21 @@
 package market
 
 import (
+	"errors"
 	"log"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/shopspring/decimal"
 	"go.uber.org/multierr"
 )
 
+// MarketPair is one market of an Exchange with its order book, trade tape and own orders.
+// Its state belongs to the pair's goroutine (see loop.go): the Strategy hooks run there,
+// and other goroutines call the order and HTTP methods through Do.
 type MarketPair struct {
-	comms *Comms
+	comms Exchange
 
 	pair  string
 	Coin  string
@@ -18,11 +23,12 @@ type MarketPair struct {
 
 	startTime time.Time
 
-	ordersHttp []marketOrders
-
-	Spec             pairSpec
-	data             MarketData
-	MyOrders         []currentOrder //CurrentOrdersPair
+	Spec             PairSpec
+	data             MarketData //last snapshot, without the order books
+	Book             *OrderBook
+	Tape             *TradeTape
+	ticker           TickerStats
+	MyOrders         []CurrentOrder //CurrentOrdersPair
 	MarketHighestBuy order
 	Market2ndBuy     order
 	MyHighestBuy     order
@@ -31,18 +37,37 @@ type MarketPair struct {
 	MyLowestSell     order
 	increment        decimal.Decimal
 
-	callBack func(m *MarketPair)
+	//own orders pushed by the private channels; HTTP polling is used while not pushed
+	ordersPushed bool
+	haveBook     bool
+	placedAt     map[string]time.Time //own orders placed by NewOrder since the last snapshot, by ID
+	fillsSince   time.Time            //of the newest fill passed to OnFill, see fills.go
+	fillsSeen    map[string]time.Time //fills passed to OnFill lately, by ID
+
+	//websocket book consistency, see bookcheck.go
+	MaxLag        int
+	ResyncTimeout time.Duration
+	haveSnapshot  bool
+	bookTrusted   bool
+	resyncing     bool
+	resyncSeq     int //of the resync in progress, its timer is ignored after that
+
+	backfilling int32 //a tape backfill is running, see BackfillTape
+
+	loop *pairLoop //the pair's goroutine, see loop.go
+
+	strategy Strategy
 
 	infoLog *log.Logger
 	warnLog *log.Logger
 	errLog  *log.Logger
 }
 
-func NewMarketPair(p string, c *Comms, s pairSpec, callbackhttp func(m *MarketPair), info *log.Logger, warn *log.Logger, er *log.Logger) MarketPair {
-	m := MarketPair{}
+func NewMarketPair(p string, c Exchange, s PairSpec, st Strategy, info *log.Logger, warn *log.Logger, er *log.Logger) *MarketPair {
+	m := &MarketPair{}
 	m.pair = p
 	m.comms = c
-	m.callBack = callbackhttp
+	m.strategy = st
 	split := strings.Split(m.pair, "-")
 	m.Coin = split[0]
 	m.Quote = split[1]
@@ -52,6 +77,11 @@ func NewMarketPair(p string, c *Comms, s pairSpec, callbackhttp func(m *MarketPa
 	m.warnLog = warn
 	m.errLog = er
 	m.increment, _ = decimal.NewFromString(s.PriceIncrement)
+	m.Book = NewOrderBook()
+	m.Tape = NewTradeTape(DefaultTapeRetention)
+	m.loop = newPairLoop()
+	m.MaxLag = DefaultMaxLag
+	m.ResyncTimeout = DefaultResyncTimeout
 	return m
 }
 
@@ -71,24 +101,17 @@ type order struct {
 	Quantity decimal.Decimal
 }
 type MarketData struct {
-	Channel  string `json:"channel"`
-	MarketID string `json:"market_id"`
-	Status   string `json:"status"`
-	Lag      int    `json:"lag"`
-	Ticker   struct {
-		Time        time.Time `json:"time"`
-		Last        string    `json:"last"`
-		Low         string    `json:"low"`
-		High        string    `json:"high"`
-		Change      string    `json:"change"`
-		BaseVolume  string    `json:"base_volume"`
-		QuoteVolume string    `json:"quote_volume"`
-	} `json:"ticker"`
-	OrderBooks []marketOrder `json:"order_books"`
-	Reset      bool          `json:"reset"`
-}
-
-type pairSpec struct {
+	Channel      string        `json:"channel"`
+	MarketID     string        `json:"market_id"`
+	Status       string        `json:"status"`
+	Lag          int           `json:"lag"`
+	Ticker       Ticker        `json:"ticker"`
+	OrderBooks   []MarketOrder `json:"order_books"`
+	RecentTrades []MarketTrade `json:"recent_trades"`
+	Reset        bool          `json:"reset"`
+}
+
+type PairSpec struct {
 	ID                string `json:"id"`
 	BaseCurrencyID    string `json:"base_currency_id"`
 	QuoteCurrencyID   string `json:"quote_currency_id"`
@@ -108,141 +131,94 @@ type pairSpec struct {
 }
 
 func (o *MarketPair) SetIncrement(i decimal.Decimal) {
-	o.increment = i
+	o.post(func() { o.increment = i })
 }
 func (o *MarketPair) GetIncrement() decimal.Decimal {
 	return o.increment
 }
 
-func (o *MarketPair) groomOrdersHttp(or *marketOrders) {
-	//remove orders with quantity==0
-	i := 0
-	l := len(or.Data)
-	for i < l {
-		if or.Data[i].Quantity == "0" {
-			or.Data = append(or.Data[:i], or.Data[i+1:]...)
-			l--
-		} else {
-			i++
-		}
+// findMarketEdges sets the best and 2nd best prices of each side from the book.
+// An empty buy side reads as price 0, an empty sell side as 999999.
+func (o *MarketPair) findMarketEdges() {
+	o.MarketHighestBuy = order{Price: decimal.Zero, Quantity: decimal.Zero}
+	o.Market2ndBuy = order{Price: decimal.Zero, Quantity: decimal.Zero}
+	o.MarketLowestSell = order{Price: decimal.NewFromInt(999999), Quantity: decimal.Zero}
+	o.Market2ndSell = order{Price: decimal.NewFromInt(999999), Quantity: decimal.Zero}
+	b := o.Book.Top("buy", 2)
+	if len(b) > 0 {
+		o.MarketHighestBuy = order{Price: b[0].Price, Quantity: b[0].Quantity}
 	}
-	or.Data = or.Data[:i]
-
-	//find HighestBuy and LowestSell
-	o.MarketHighestBuy.Price = decimal.NewFromInt(0)
-	o.MarketHighestBuy.Quantity = decimal.NewFromInt(0)
-	o.MarketLowestSell.Price = decimal.NewFromInt(999999)
-	o.MarketLowestSell.Quantity = decimal.NewFromInt(0)
-	for _, r := range or.Data {
-		p, _ := decimal.NewFromString(r.Price)
-		q, _ := decimal.NewFromString(r.Quantity)
-		b := (r.Side == "buy")
-		if b {
-			if p.GreaterThanOrEqual(o.MarketHighestBuy.Price) && q.IsPositive() {
-				o.MarketHighestBuy.Price = p
-				o.MarketHighestBuy.Quantity = q
-			}
-		} else {
-			if p.LessThanOrEqual(o.MarketLowestSell.Price) && q.IsPositive() {
-				o.MarketLowestSell.Price = p
-				o.MarketLowestSell.Quantity = q
-			}
-		}
+	if len(b) > 1 {
+		o.Market2ndBuy = order{Price: b[1].Price, Quantity: b[1].Quantity}
 	}
-
-	//find 2ndBuy and 2ndSell
-	o.Market2ndBuy.Price = decimal.NewFromInt(0)
-	o.Market2ndBuy.Quantity = decimal.NewFromInt(0)
-	o.Market2ndSell.Price = decimal.NewFromInt(999999)
-	o.Market2ndSell.Quantity = decimal.NewFromInt(0)
-	for _, r := range or.Data {
-		p, _ := decimal.NewFromString(r.Price)
-		q, _ := decimal.NewFromString(r.Quantity)
-		b := (r.Side == "buy")
-		if b {
-			if p.GreaterThan(o.Market2ndBuy.Price) && q.IsPositive() && !p.Equal(o.MarketHighestBuy.Price) {
-				o.Market2ndBuy.Price = p
-				o.Market2ndBuy.Quantity = q
-			}
-		} else {
-			if p.LessThan(o.Market2ndSell.Price) && q.IsPositive() && !p.Equal(o.MarketLowestSell.Price) {
-				o.Market2ndSell.Price = p
-				o.Market2ndSell.Quantity = q
-			}
-		}
+	a := o.Book.Top("sell", 2)
+	if len(a) > 0 {
+		o.MarketLowestSell = order{Price: a[0].Price, Quantity: a[0].Quantity}
+	}
+	if len(a) > 1 {
+		o.Market2ndSell = order{Price: a[1].Price, Quantity: a[1].Quantity}
 	}
 }
+
+// UpdateMarketData queues a marketdata packet for the pair's goroutine.
 func (o *MarketPair) UpdateMarketData(d MarketData) {
+	o.post(func() { o.updateMarketData(d) })
+}
+func (o *MarketPair) updateMarketData(d MarketData) {
+	o.updateTicker(d.Ticker)
+	last, tapeFound := o.Tape.Last()
+	if bad := o.Tape.Add(d.RecentTrades); bad > 0 {
+		o.errLog.Println(o.pair, "bad trades skipped:", bad)
+	}
+	if d.Reset {
+		//a new subscription: trades between the last known one and the snapshot were missed
+		since := time.Now().Add(-o.Tape.Retention())
+		if tapeFound {
+			since = last.Time
+		}
+		go o.BackfillTape(since)
+	}
+
+	var err error
 	if d.Reset == true { //a complete packet, not only diff
-		//json.Unmarshal([]byte(message), &o.data)
+		err = o.Book.Load(d.OrderBooks)
 		o.data = d
+		o.data.OrderBooks = nil //kept in Book
+		o.haveSnapshot = err == nil
 		o.warnLog.Println(o.pair, " The first marketData packet stored")
+	} else if !o.haveSnapshot { //a diff without a base can't be applied
+		o.resync("diff before snapshot")
+		return
 	} else { //a short diff packet
-		for _, newOrder := range d.OrderBooks {
-			found := false
-			for i, oldOrder := range o.data.OrderBooks {
-				if newOrder.Price == oldOrder.Price && newOrder.Side == oldOrder.Side {
-					if found {
-						o.infoLog.Println(o.pair, "A duplicate?")
-					}
-					o.infoLog.Println(o.pair, "new order applied")
-					//oldOrder.Quantity = newOrder.Quantity
-					o.data.OrderBooks[i].Quantity = newOrder.Quantity
-					found = true
-				}
-			}
-			if !found {
-				o.data.OrderBooks = append(o.data.OrderBooks, newOrder)
-				o.infoLog.Println(o.pair, "new order appended")
-			}
-		}
+		err = o.Book.Apply(d.OrderBooks)
 		o.infoLog.Println(o.pair, "A diff packet stored")
 	}
+	if err != nil {
+		o.errLog.Println(o.pair, "bad order book entry:", err)
+		o.haveSnapshot = false
+		o.resync("bad order book entry")
+		return
+	}
 
-	o.groomOrders()
+	o.findMarketEdges()
+	o.haveBook = true
 	o.infoLog.Println(o.pair, "Market:", o.MarketLowestSell.Price, "(", o.MarketLowestSell.Quantity, ")-", o.MarketHighestBuy.Price, "(", o.MarketHighestBuy.Quantity, ")")
-	o.callBack(o)
-}
-func (o *MarketPair) groomOrders() {
-	if o.MarketLowestSell.Price.IsZero() {
-		o.MarketLowestSell.Price = decimal.NewFromInt(9999999) //TODO: o.spec.MaxPrice
-	}
-	//remove orders with quantity==0
-	o.infoLog.Println(o.pair, " no of order:", len(o.data.OrderBooks))
-	i := 0
-	l := len(o.data.OrderBooks)
-	for i < l {
-		if o.data.OrderBooks[i].Quantity == "0" {
-			o.data.OrderBooks = append(o.data.OrderBooks[:i], o.data.OrderBooks[i+1:]...)
-			l--
-		} else {
-			i++
+	if reason := o.bookProblem(d); reason != "" {
+		if o.bookTrusted {
+			o.warnLog.Println(o.pair, "holding callbacks:", reason)
 		}
-	}
-	o.data.OrderBooks = o.data.OrderBooks[:i]
-	o.infoLog.Println(o.pair, " no of order After:", len(o.data.OrderBooks))
-
-	//find HighestBuy and LowestSell
-	o.MarketHighestBuy.Price = decimal.NewFromInt(0)
-	o.MarketHighestBuy.Quantity = decimal.NewFromInt(0)
-	o.MarketLowestSell.Price = decimal.NewFromInt(999999)
-	o.MarketLowestSell.Quantity = decimal.NewFromInt(0)
-	for _, r := range o.data.OrderBooks {
-		p, _ := decimal.NewFromString(r.Price)
-		q, _ := decimal.NewFromString(r.Quantity)
-		b := (r.Side == "buy")
-		if b {
-			if p.GreaterThanOrEqual(o.MarketHighestBuy.Price) && q.IsPositive() {
-				o.MarketHighestBuy.Price = p
-				o.MarketHighestBuy.Quantity = q
-			}
-		} else {
-			if p.LessThanOrEqual(o.MarketLowestSell.Price) && q.IsPositive() {
-				o.MarketLowestSell.Price = p
-				o.MarketLowestSell.Quantity = q
-			}
+		if o.bookCrossed() {
+			o.haveSnapshot = false //later diffs would apply to a wrong book, wait for a new base
 		}
+		o.resync(reason)
+		return
+	}
+	if !o.bookTrusted {
+		o.warnLog.Println(o.pair, "order book trusted again")
 	}
+	o.bookTrusted = true
+	o.resyncing = false
+	o.strategy.OnBookUpdate(o)
 }
 func (o *MarketPair) UpdateMarketHttp() error {
 	r, er := o.comms.GetMarketOrdersHttp(o.pair)
@@ -250,13 +226,26 @@ func (o *MarketPair) UpdateMarketHttp() error {
 		o.errLog.Println(er)
 		return er
 	}
-	o.groomOrdersHttp(r)
-	o.UpdateMyOrders()
+	if e := o.Book.Load(r.Data); e != nil {
+		o.errLog.Println(o.pair, "bad order book entry:", e)
+		return e
+	}
+	o.findMarketEdges()
+	o.haveBook = true
+	if o.bookCrossed() {
+		o.bookTrusted = false
+		o.warnLog.Println(o.pair, "crossed HTTP order book, skipping callback")
+		return ErrBookInconsistent
+	}
+	o.bookTrusted = true
+	if !o.ordersPushed {
+		er = o.UpdateMyOrders()
+	}
 	if er != nil {
 		o.errLog.Println(er)
 		return er
 	}
-	o.callBack(o)
+	o.strategy.OnBookUpdate(o)
 	return nil
 }
 
@@ -267,7 +256,10 @@ func (o *MarketPair) UpdateMarketEdgeOrders() error {
 		o.errLog.Println(er)
 		return er
 	}
-	o.groomOrdersHttp(r)
+	if er = o.Book.Load(r.Data); er != nil {
+		o.errLog.Println(o.pair, "bad order book entry:", er)
+	}
+	o.findMarketEdges()
 	return nil
 }
 
@@ -283,13 +275,28 @@ type Order struct {
 	ClientOrderID string `json:"client_order_id"`
 }
 
-type cancelingOrder struct {
+type CancelingOrder struct {
 	MarketID string `json:"market_id"`
 	OrderID  string `json:"order_id"`
 }
 
-func (o *MarketPair) NewOrder(r Order) error {
-	return o.comms.NewOrder(r)
+// NewOrder places r and records the created order in MyOrders right away,
+// so the edges are current before the next poll.
+func (o *MarketPair) NewOrder(r Order) (CurrentOrder, error) {
+	c, err := o.comms.NewOrder(r)
+	if err != nil {
+		return c, err
+	}
+	o.infoLog.Println(o.pair, "order placed:", c.ID, c.Side, c.LimitPrice, c.Quantity, c.Status)
+	if c.Status == "open" {
+		o.MyOrders = append(o.MyOrders, c)
+		o.findMyEdges()
+		if o.placedAt == nil {
+			o.placedAt = make(map[string]time.Time)
+		}
+		o.placedAt[c.ID] = time.Now()
+	}
+	return c, nil
 }
 func (o *MarketPair) CancelOrders(buysell string) error {
 	//cancels all orders with side buysell. buysell is either buy or sell
@@ -297,8 +304,8 @@ func (o *MarketPair) CancelOrders(buysell string) error {
 	var err error
 	for _, d := range o.MyOrders {
 		if d.Side == buysell && d.MarketID == o.pair {
-			c := cancelingOrder{MarketID: o.pair, OrderID: d.ID}
-			e := o.comms.CancelOrder(c)
+			c := CancelingOrder{MarketID: o.pair, OrderID: d.ID}
+			_, e := o.comms.CancelOrder(c)
 			err = multierr.Append(err, e)
 		}
 	}
@@ -307,6 +314,101 @@ func (o *MarketPair) CancelOrders(buysell string) error {
 	}
 	return err
 }
+
+// CancelOrder cancels one own order and drops it from MyOrders right away,
+// so the edges are current before the order_history push confirms it.
+func (o *MarketPair) CancelOrder(id string) error {
+	if _, err := o.comms.CancelOrder(CancelingOrder{MarketID: o.pair, OrderID: id}); err != nil {
+		o.infoLog.Println("error in canceling order:", id, err, o.pair)
+		return err
+	}
+	o.dropMyOrder(id)
+	return nil
+}
+
+var (
+	ErrRepriceCrosses  = errors.New("reprice: new price crosses the book")
+	ErrRepriceQuantity = errors.New("reprice: canceled quantity unknown, not replaced")
+	ErrRepriceTooSmall = errors.New("reprice: rest of the order below the market minimum, not replaced")
+)
+
+// Reprice moves own order id to price: it cancels the order and at once puts what the
+// cancel took off the book at the new price, with the same side and time in force.
+// Nothing is placed unless the cancel succeeds, so a failed cancel or an order already
+// filled (ErrOrderNotFound) never doubles the exposure, and a partial fill before the
+// cancel only shrinks the replacement. A price crossing the book is refused before the
+// cancel. On errors after the cancel the pair is out of the market on that side.
+func (o *MarketPair) Reprice(id string, price decimal.Decimal) (CurrentOrder, error) {
+	var old CurrentOrder
+	found := false
+	for _, d := range o.MyOrders {
+		if d.ID == id {
+			old, found = d, true
+			break
+		}
+	}
+	if !found {
+		return CurrentOrder{}, ErrOrderNotFound
+	}
+	//only against an opposite side that has orders, the edge prices of an empty one are placeholders
+	if ask, found := o.Book.Best("sell"); found && old.Side == "buy" && price.GreaterThanOrEqual(ask.Price) {
+		return CurrentOrder{}, ErrRepriceCrosses
+	}
+	if bid, found := o.Book.Best("buy"); found && old.Side == "sell" && price.LessThanOrEqual(bid.Price) {
+		return CurrentOrder{}, ErrRepriceCrosses
+	}
+
+	c, err := o.comms.CancelOrder(CancelingOrder{MarketID: o.pair, OrderID: id})
+	if err != nil {
+		if errors.Is(err, ErrOrderNotFound) {
+			o.dropMyOrder(id) //filled or cancelled meanwhile
+		}
+		o.infoLog.Println(o.pair, "reprice: cancel failed, nothing placed:", id, err)
+		return CurrentOrder{}, err
+	}
+	o.dropMyOrder(id)
+	q, e := decimal.NewFromString(c.CancelledQuantity)
+	if c.ID != id || e != nil {
+		o.errLog.Println(o.pair, "reprice: no canceled quantity in the cancel response of", id)
+		return CurrentOrder{}, ErrRepriceQuantity
+	}
+	q = q.Truncate(int32(o.Spec.QuantityPrecision))
+	minQ, _ := decimal.NewFromString(o.Spec.MinQuantity)
+	minC, _ := decimal.NewFromString(o.Spec.MinCost)
+	if q.LessThan(minQ) || q.Mul(price).LessThan(minC) {
+		o.infoLog.Println(o.pair, "reprice:", id, "rest", q, "too small to put again")
+		return CurrentOrder{}, ErrRepriceTooSmall
+	}
+	tif := old.TimeInForce
+	if tif == "" {
+		tif = "gtc"
+	}
+	n, err := o.NewOrder(Order{MarketID: o.pair, Type: "limit", Side: old.Side, TimeInForce: tif, LimitPrice: price.String(), Quantity: q.String()})
+	if err != nil {
+		o.errLog.Println(o.pair, "reprice: canceled", id, "but the replacement failed:", err)
+		return CurrentOrder{}, err
+	}
+	o.infoLog.Println(o.pair, "repriced", old.Side, id, old.LimitPrice, "->", n.ID, n.LimitPrice, "quantity", q)
+	return n, nil
+}
+
+// dropMyOrder removes an order from MyOrders after it left the book.
+func (o *MarketPair) dropMyOrder(id string) {
+	for i, d := range o.MyOrders {
+		if d.ID == id {
+			o.MyOrders = append(o.MyOrders[:i], o.MyOrders[i+1:]...)
+			break
+		}
+	}
+	delete(o.placedAt, id)
+	o.findMyEdges()
+}
+
+// LoadMyOrders queues UpdateMyOrders for the pair's goroutine. Later changes come
+// from the private channels, by ID.
+func (o *MarketPair) LoadMyOrders() {
+	o.post(func() { o.UpdateMyOrders() }) //errors are logged by UpdateMyOrders
+}
 func (o *MarketPair) UpdateMyOrders() error {
 	bp, bq, sp, sq := o.MyHighestBuy.Price, o.MyHighestBuy.Quantity, o.MyLowestSell.Price, o.MyLowestSell.Quantity
 	o.infoLog.Println(o.pair, "Getting existing orders")
@@ -315,8 +417,43 @@ func (o *MarketPair) UpdateMyOrders() error {
 		o.errLog.Println(o.pair, "error is recieving orders:", err)
 		return err
 	}
+	if ordersFilled(o.MyOrders, orders) {
+		o.comms.InvalidateBalances()
+		if !o.ordersPushed {
+			o.loadFills() //fills aren't pushed either
+		}
+	}
 	o.MyOrders = orders
+	o.placedAt = nil
+	o.findMyEdges()
+	if !(bp.Equal(o.MyHighestBuy.Price) && bq.Equal(o.MyHighestBuy.Quantity) && sp.Equal(o.MyLowestSell.Price) && sq.Equal(o.MyLowestSell.Quantity)) {
+		o.infoLog.Println(o.pair, " my edge orders: ", o.MyLowestSell.Price, "(", o.MyLowestSell.Quantity, ")  ", o.MyHighestBuy.Price, "(", o.MyHighestBuy.Quantity, ")")
+	}
+	return nil
+}
 
+// ordersFilled is true if an order of old left the open orders or was filled further in current.
+func ordersFilled(old []CurrentOrder, current []CurrentOrder) bool {
+	filled := make(map[string]string, len(current))
+	for _, c := range current {
+		filled[c.ID] = c.FilledQuantity
+	}
+	for _, d := range old {
+		f, found := filled[d.ID]
+		if !found {
+			return true
+		}
+		a, _ := decimal.NewFromString(f)
+		b, _ := decimal.NewFromString(d.FilledQuantity)
+		if !a.Equal(b) {
+			return true
+		}
+	}
+	return false
+}
+
+// findMyEdges sets MyHighestBuy and MyLowestSell from MyOrders
+func (o *MarketPair) findMyEdges() {
 	o.MyHighestBuy = order{}
 	o.MyLowestSell = order{}
 	for _, d := range o.MyOrders {
@@ -333,16 +470,101 @@ func (o *MarketPair) UpdateMyOrders() error {
 			o.MyLowestSell.Quantity = q
 		}
 	}
-	if !(bp.Equal(o.MyHighestBuy.Price) && bq.Equal(o.MyHighestBuy.Quantity) && sp.Equal(o.MyLowestSell.Price) && sq.Equal(o.MyLowestSell.Quantity)) {
-		o.infoLog.Println(o.pair, " my edge orders: ", o.MyLowestSell.Price, "(", o.MyLowestSell.Quantity, ")  ", o.MyHighestBuy.Price, "(", o.MyHighestBuy.Quantity, ")")
+}
+func (o *MarketPair) GetBalanceAndAvail() (decimal.Decimal, decimal.Decimal) {
+	return o.comms.GetBalanceAndAvail(o.Coin)
+}
+
+// PushMyOrders applies own orders from the open_order/order_history channels.
+// reset replaces MyOrders with a snapshot; otherwise each order is updated by ID
+// and dropped once it is no longer open.
+func (o *MarketPair) PushMyOrders(orders []CurrentOrder, reset bool) {
+	at := time.Now()
+	o.post(func() { o.pushMyOrders(orders, reset, at) })
+}
+
+// pushMyOrders runs on the loop; at is when Comms received the orders. A snapshot
+// keeps the orders placed after it, which it can't contain.
+func (o *MarketPair) pushMyOrders(orders []CurrentOrder, reset bool, at time.Time) {
+	if reset {
+		var newer []CurrentOrder
+		for _, d := range o.MyOrders {
+			if o.placedAt[d.ID].After(at) {
+				newer = append(newer, d)
+			}
+		}
+		for id, t := range o.placedAt {
+			if !t.After(at) {
+				delete(o.placedAt, id)
+			}
+		}
+		o.MyOrders = newer
+		o.ordersPushed = true
+	}
+	for _, c := range orders {
+		found := false
+		for i, d := range o.MyOrders {
+			if d.ID == c.ID {
+				found = true
+				if c.Status == "open" {
+					o.MyOrders[i] = c
+				} else {
+					o.MyOrders = append(o.MyOrders[:i], o.MyOrders[i+1:]...)
+					delete(o.placedAt, c.ID)
+				}
+				break
+			}
+		}
+		if !found && c.Status == "open" {
+			o.MyOrders = append(o.MyOrders, c)
+		}
+	}
+	o.findMyEdges()
+	o.infoLog.Println(o.pair, "pushed orders:", len(orders), "my edge orders:", o.MyLowestSell.Price, o.MyHighestBuy.Price)
+	o.strategy.OnOwnOrderUpdate(o, orders)
+}
+
+func (o *MarketPair) PushMyTrades(trades []MyTrade) {
+	o.post(func() { o.passFills(trades) })
+}
+
+// SocketLost is called when the websocket drops. Orders fall back to HTTP polling until
+// the private channels send a new snapshot, and the book waits for a new marketdata snapshot.
+func (o *MarketPair) SocketLost() {
+	o.post(func() {
+		o.ordersPushed = false
+		o.haveSnapshot = false
+		o.bookTrusted = false
+		o.resyncing = false
+		o.strategy.OnDisconnect(o)
+	})
+}
+
+// BackfillTape loads the public trades since a time from REST into the tape.
+// Only one backfill runs at a time; a call during another one returns nil.
+func (o *MarketPair) BackfillTape(since time.Time) error {
+	if !atomic.CompareAndSwapInt32(&o.backfilling, 0, 1) {
+		return nil
 	}
+	defer atomic.StoreInt32(&o.backfilling, 0)
+	t, err := o.comms.GetMarketTrades(o.pair, since, time.Now())
+	if err != nil {
+		o.errLog.Println(o.pair, "tape backfill failed:", err)
+		return err
+	}
+	if bad := o.Tape.Add(t.Data); bad > 0 {
+		o.errLog.Println(o.pair, "bad trades skipped:", bad)
+	}
+	o.infoLog.Println(o.pair, "tape backfilled:", len(t.Data), "trades since", since)
 	return nil
 }
-func (o MarketPair) GetBalanceAndAvail() (decimal.Decimal, decimal.Decimal) {
-	//!! TODO: check if possible: comms gets balance once for all and keep it
-	return o.comms.GetBalanceAndAvail(o.Coin)
+
+// TapeStats aggregates the public trades of the last window, see TradeTape.Stats.
+func (o *MarketPair) TapeStats(window time.Duration) TapeStats {
+	return o.Tape.Stats(window)
 }
-func (o MarketPair) ReportHistory() (decimal.Decimal, decimal.Decimal, error) {
+
+func (o *MarketPair) ReportHistory() (decimal.Decimal, decimal.Decimal, error) {
 	//returns the added amount of base and added (-spent) of quote coin
 	h, err := o.comms.GetTradeHistory(o.pair, o.startTime, time.Now())
 	if err != nil {
@@ -0,0 +1,103 @@
+package market
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CommsOptions selects the ProBit endpoints and the HTTP behaviour of Comms.
+// Zero fields are filled from DefaultCommsOptions.
+type CommsOptions struct {
+	RestURL string //prefixed to /api/exchange/v1/...
+	AuthURL string //prefixed to /token
+	WsURL   string
+
+	Credentials CredentialProvider //nil: FileCredentials from the working directory
+
+	HTTPClient     *http.Client  //nil: a new client is made with RequestTimeout
+	RequestTimeout time.Duration //whole request including body read; also set on HTTPClient when it has none
+	DialTimeout    time.Duration //websocket handshake
+
+	ReconnectMin     time.Duration //first websocket reconnect delay, doubled per failed attempt
+	ReconnectMax     time.Duration //cap of the reconnect delay
+	PingInterval     time.Duration //websocket ping period
+	HeartbeatTimeout time.Duration //reconnect when nothing is received for this long
+	SubscribeTimeout time.Duration //a marketdata subscription without a packet for this long is sent again
+
+	RateBudgets map[EndpointClass]RateBudget //per class overrides of the default budgets, ignored without PerSecond or Burst
+
+	BalanceReconcile time.Duration //how often the balance book is reloaded from /balance
+}
+
+func DefaultCommsOptions() CommsOptions {
+	return CommsOptions{
+		RestURL:        "https://api.probit.com",
+		AuthURL:        "https://accounts.probit.com",
+		WsURL:          "wss://api.probit.com/api/exchange/v1/ws",
+		RequestTimeout: 15 * time.Second,
+		DialTimeout:    10 * time.Second,
+
+		ReconnectMin:     500 * time.Millisecond,
+		ReconnectMax:     time.Minute,
+		PingInterval:     15 * time.Second,
+		HeartbeatTimeout: 45 * time.Second,
+		SubscribeTimeout: 10 * time.Second,
+
+		BalanceReconcile: time.Minute,
+	}
+}
+
+func (o CommsOptions) withDefaults() CommsOptions {
+	d := DefaultCommsOptions()
+	if o.RestURL == "" {
+		o.RestURL = d.RestURL
+	}
+	if o.AuthURL == "" {
+		o.AuthURL = d.AuthURL
+	}
+	if o.WsURL == "" {
+		o.WsURL = d.WsURL
+	}
+	if o.RequestTimeout == 0 {
+		o.RequestTimeout = d.RequestTimeout
+	}
+	if o.DialTimeout == 0 {
+		o.DialTimeout = d.DialTimeout
+	}
+	if o.ReconnectMin == 0 {
+		o.ReconnectMin = d.ReconnectMin
+	}
+	if o.ReconnectMax < o.ReconnectMin {
+		o.ReconnectMax = d.ReconnectMax
+		if o.ReconnectMax < o.ReconnectMin {
+			o.ReconnectMax = o.ReconnectMin
+		}
+	}
+	if o.PingInterval == 0 {
+		o.PingInterval = d.PingInterval
+	}
+	if o.HeartbeatTimeout == 0 {
+		o.HeartbeatTimeout = d.HeartbeatTimeout
+	}
+	if o.SubscribeTimeout == 0 {
+		o.SubscribeTimeout = d.SubscribeTimeout
+	}
+	if o.BalanceReconcile == 0 {
+		o.BalanceReconcile = d.BalanceReconcile
+	}
+	if o.Credentials == nil {
+		o.Credentials = FileCredentials{}
+	}
+	o.RestURL = strings.TrimSuffix(o.RestURL, "/")
+	o.AuthURL = strings.TrimSuffix(o.AuthURL, "/")
+	if o.HTTPClient == nil {
+		o.HTTPClient = &http.Client{Timeout: o.RequestTimeout}
+	} else if o.HTTPClient.Timeout == 0 {
+		//don't change the caller's client, a shallow copy shares its transport
+		c := *o.HTTPClient
+		c.Timeout = o.RequestTimeout
+		o.HTTPClient = &c
+	}
+	return o
+}
@@ -0,0 +1,200 @@
+package market
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// PriceLevel is the total quantity resting at one price. In Depth results
+// Quantity is cumulative from the best price.
+type PriceLevel struct {
+	Price    decimal.Decimal
+	Quantity decimal.Decimal
+}
+
+// OrderBook keeps the market's price levels sorted, best first on each side.
+// Bids and asks are skip lists keyed by price: a level update is O(log n) and
+// reading the top of a side doesn't scan the rest of the book.
+type OrderBook struct {
+	bids *levelList
+	asks *levelList
+}
+
+func NewOrderBook() *OrderBook {
+	return &OrderBook{
+		bids: newLevelList(func(a, b decimal.Decimal) bool { return a.GreaterThan(b) }),
+		asks: newLevelList(func(a, b decimal.Decimal) bool { return a.LessThan(b) }),
+	}
+}
+
+func (o *OrderBook) side(s string) *levelList {
+	if s == "buy" {
+		return o.bids
+	}
+	return o.asks
+}
+
+// Set stores the quantity at a price; zero or negative removes the level.
+func (o *OrderBook) Set(side string, price decimal.Decimal, quantity decimal.Decimal) {
+	o.side(side).set(price, quantity)
+}
+
+// Apply sets every level of a diff packet. Unparsable entries are returned as an error
+// after the others are applied.
+func (o *OrderBook) Apply(orders []MarketOrder) error {
+	var err error
+	for _, r := range orders {
+		p, e1 := decimal.NewFromString(r.Price)
+		q, e2 := decimal.NewFromString(r.Quantity)
+		if e1 != nil {
+			err = e1
+			continue
+		}
+		if e2 != nil {
+			err = e2
+			continue
+		}
+		o.Set(r.Side, p, q)
+	}
+	return err
+}
+
+// Load replaces the whole book with a snapshot.
+func (o *OrderBook) Load(orders []MarketOrder) error {
+	o.bids.clear()
+	o.asks.clear()
+	return o.Apply(orders)
+}
+
+func (o *OrderBook) Len(side string) int {
+	return o.side(side).length
+}
+
+// Best is the top level of a side; false if the side is empty.
+func (o *OrderBook) Best(side string) (PriceLevel, bool) {
+	n := o.side(side).head.next[0]
+	if n == nil {
+		return PriceLevel{}, false
+	}
+	return PriceLevel{Price: n.price, Quantity: n.qty}, true
+}
+
+// Top returns up to n levels of a side, best first.
+func (o *OrderBook) Top(side string, n int) []PriceLevel {
+	var l []PriceLevel
+	for x := o.side(side).head.next[0]; x != nil && len(l) < n; x = x.next[0] {
+		l = append(l, PriceLevel{Price: x.price, Quantity: x.qty})
+	}
+	return l
+}
+
+// Depth is like Top with cumulative quantities.
+func (o *OrderBook) Depth(side string, n int) []PriceLevel {
+	l := o.Top(side, n)
+	for i := 1; i < len(l); i++ {
+		l[i].Quantity = l[i].Quantity.Add(l[i-1].Quantity)
+	}
+	return l
+}
+
+// VWAP is the average price of taking quantity from a side, e.g. side "sell" for a
+// market buy. If the side is too thin it returns the average of what is there and false.
+func (o *OrderBook) VWAP(side string, quantity decimal.Decimal) (decimal.Decimal, bool) {
+	left := quantity
+	cost := decimal.Zero
+	for x := o.side(side).head.next[0]; x != nil && left.IsPositive(); x = x.next[0] {
+		q := decimal.Min(left, x.qty)
+		cost = cost.Add(q.Mul(x.price))
+		left = left.Sub(q)
+	}
+	taken := quantity.Sub(left)
+	if taken.IsZero() {
+		return decimal.Zero, false
+	}
+	return cost.Div(taken), !left.IsPositive()
+}
+
+/////////////////////////////////////skip list of price levels
+const maxSkipLevel = 16
+
+type levelNode struct {
+	price decimal.Decimal
+	qty   decimal.Decimal
+	next  []*levelNode
+}
+
+type levelList struct {
+	head   *levelNode
+	level  int
+	length int
+	before func(a, b decimal.Decimal) bool //sort order: true if a is a better price than b
+	seed   uint32
+}
+
+func newLevelList(before func(a, b decimal.Decimal) bool) *levelList {
+	l := levelList{before: before, seed: 2463534242}
+	l.clear()
+	return &l
+}
+
+func (o *levelList) clear() {
+	o.head = &levelNode{next: make([]*levelNode, maxSkipLevel)}
+	o.level = 1
+	o.length = 0
+}
+
+// randomLevel gives level k with probability 1/2^k (xorshift32).
+func (o *levelList) randomLevel() int {
+	lvl := 1
+	for lvl < maxSkipLevel {
+		o.seed ^= o.seed << 13
+		o.seed ^= o.seed >> 17
+		o.seed ^= o.seed << 5
+		if o.seed&1 == 0 {
+			break
+		}
+		lvl++
+	}
+	return lvl
+}
+
+func (o *levelList) set(price decimal.Decimal, qty decimal.Decimal) {
+	var update [maxSkipLevel]*levelNode
+	x := o.head
+	for i := o.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && o.before(x.next[i].price, price) {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+	n := x.next[0]
+	if n != nil && n.price.Equal(price) {
+		if qty.IsPositive() {
+			n.qty = qty
+			return
+		}
+		for i := 0; i < o.level && update[i].next[i] == n; i++ {
+			update[i].next[i] = n.next[i]
+		}
+		for o.level > 1 && o.head.next[o.level-1] == nil {
+			o.level--
+		}
+		o.length--
+		return
+	}
+	if !qty.IsPositive() {
+		return
+	}
+	lvl := o.randomLevel()
+	for i := o.level; i < lvl; i++ {
+		update[i] = o.head
+	}
+	if lvl > o.level {
+		o.level = lvl
+	}
+	n = &levelNode{price: price, qty: qty, next: make([]*levelNode, lvl)}
+	for i := 0; i < lvl; i++ {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+	o.length++
+}
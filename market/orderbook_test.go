@@ -0,0 +1,148 @@
+package market_test
+
+import (
+	"arbiter/market"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// levels writes price levels as "price:quantity" separated by spaces.
+func levels(l []market.PriceLevel) string {
+	var s []string
+	for _, x := range l {
+		s = append(s, x.Price.String()+":"+x.Quantity.String())
+	}
+	return strings.Join(s, " ")
+}
+
+// book is a book loaded with bids 99:1 98:2 97:3 and asks 101:1 102:2 103:3.
+func book(t *testing.T) *market.OrderBook {
+	t.Helper()
+	b := market.NewOrderBook()
+	err := b.Load([]market.MarketOrder{
+		{Side: "buy", Price: "98", Quantity: "2"}, {Side: "buy", Price: "99", Quantity: "1"}, {Side: "buy", Price: "97", Quantity: "3"},
+		{Side: "sell", Price: "103", Quantity: "3"}, {Side: "sell", Price: "101", Quantity: "1"}, {Side: "sell", Price: "102", Quantity: "2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestOrderBookApply(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		diff       []market.MarketOrder
+		bids, asks string
+		bad        bool
+	}{
+		{"insert best", []market.MarketOrder{{Side: "buy", Price: "99.5", Quantity: "4"}, {Side: "sell", Price: "100.5", Quantity: "4"}},
+			"99.5:4 99:1 98:2 97:3", "100.5:4 101:1 102:2 103:3", false},
+		{"insert inside", []market.MarketOrder{{Side: "buy", Price: "97.5", Quantity: "4"}, {Side: "sell", Price: "102.5", Quantity: "4"}},
+			"99:1 98:2 97.5:4 97:3", "101:1 102:2 102.5:4 103:3", false},
+		{"insert worst", []market.MarketOrder{{Side: "buy", Price: "90", Quantity: "4"}, {Side: "sell", Price: "110", Quantity: "4"}},
+			"99:1 98:2 97:3 90:4", "101:1 102:2 103:3 110:4", false},
+		{"update", []market.MarketOrder{{Side: "buy", Price: "98", Quantity: "5"}, {Side: "sell", Price: "101", Quantity: "0.5"}},
+			"99:1 98:5 97:3", "101:0.5 102:2 103:3", false},
+		{"zero deletes", []market.MarketOrder{{Side: "buy", Price: "99", Quantity: "0"}, {Side: "sell", Price: "102", Quantity: "0"}},
+			"98:2 97:3", "101:1 103:3", false},
+		{"zero of a missing level", []market.MarketOrder{{Side: "buy", Price: "98.5", Quantity: "0"}},
+			"99:1 98:2 97:3", "101:1 102:2 103:3", false},
+		{"bad entry, the others applied", []market.MarketOrder{{Side: "buy", Price: "x", Quantity: "1"}, {Side: "sell", Price: "101", Quantity: "0"}},
+			"99:1 98:2 97:3", "102:2 103:3", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := book(t)
+			err := b.Apply(tc.diff)
+			if (err != nil) != tc.bad {
+				t.Errorf("error %v, want one: %v", err, tc.bad)
+			}
+			if got := levels(b.Top("buy", 10)); got != tc.bids {
+				t.Errorf("bids %s, want %s", got, tc.bids)
+			}
+			if got := levels(b.Top("sell", 10)); got != tc.asks {
+				t.Errorf("asks %s, want %s", got, tc.asks)
+			}
+		})
+	}
+}
+
+// The skip list keeps the order of many random updates, like a sorted map does.
+func TestOrderBookOrdering(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	b := market.NewOrderBook()
+	want := make(map[int64]int64)
+	for i := 0; i < 5000; i++ {
+		p, q := r.Int63n(1000)+1, r.Int63n(4) //a quarter are deletes
+		b.Set("buy", decimal.NewFromInt(p), decimal.NewFromInt(q))
+		if q == 0 {
+			delete(want, p)
+		} else {
+			want[p] = q
+		}
+	}
+	var prices []int64
+	for p := range want {
+		prices = append(prices, p)
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i] > prices[j] })
+	got := b.Top("buy", len(prices)+1)
+	if len(got) != len(prices) || b.Len("buy") != len(prices) {
+		t.Fatalf("%d levels (Len %d), want %d", len(got), b.Len("buy"), len(prices))
+	}
+	for i, p := range prices {
+		if got[i].Price.IntPart() != p || got[i].Quantity.IntPart() != want[p] {
+			t.Fatalf("level %d is %s:%s, want %d:%d", i, got[i].Price, got[i].Quantity, p, want[p])
+		}
+	}
+}
+
+func TestOrderBookDepth(t *testing.T) {
+	b := book(t)
+	for _, tc := range []struct {
+		side string
+		n    int
+		want string
+	}{
+		{"buy", 2, "99:1 98:3"},
+		{"buy", 10, "99:1 98:3 97:6"},
+		{"sell", 3, "101:1 102:3 103:6"},
+		{"sell", 0, ""},
+	} {
+		if got := levels(b.Depth(tc.side, tc.n)); got != tc.want {
+			t.Errorf("%s depth %d: %s, want %s", tc.side, tc.n, got, tc.want)
+		}
+	}
+	//Depth returns copies, the book keeps its quantities
+	if got := levels(b.Top("buy", 3)); got != "99:1 98:2 97:3" {
+		t.Errorf("bids after Depth: %s", got)
+	}
+}
+
+func TestOrderBookVWAP(t *testing.T) {
+	b := book(t)
+	for _, tc := range []struct {
+		side     string
+		quantity string
+		price    string
+		enough   bool
+	}{
+		{"sell", "0.5", "101", true},                  //part of the best level
+		{"sell", "1", "101", true},                    //exactly the best level
+		{"sell", "2", "101.5", true},                  //1 at 101, 1 at 102
+		{"sell", "6", "102.3333333333333333", true},   //the whole side
+		{"sell", "10", "102.3333333333333333", false}, //too thin: the average of all
+		{"buy", "4", "98", true},                      //1 at 99, 2 at 98, 1 at 97
+	} {
+		p, enough := b.VWAP(tc.side, decimal.RequireFromString(tc.quantity))
+		if enough != tc.enough || !p.Equal(decimal.RequireFromString(tc.price)) {
+			t.Errorf("%s VWAP of %s: %s %v, want %s %v", tc.side, tc.quantity, p, enough, tc.price, tc.enough)
+		}
+	}
+	if p, enough := market.NewOrderBook().VWAP("sell", decimal.NewFromInt(1)); enough || !p.IsZero() {
+		t.Errorf("VWAP of an empty side: %s %v, want 0 false", p, enough)
+	}
+}
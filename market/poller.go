@@ -0,0 +1,165 @@
+package market
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPollInterval     = 5 * time.Second
+	DefaultLivePollInterval = time.Second //for pairs with own open orders
+	DefaultPollReserve      = 0.3         //share of the public and account budgets kept for order calls
+	pollTick                = 100 * time.Millisecond
+)
+
+// Poller drives pairs over HTTP instead of the websocket: it calls UpdateMarketHttp
+// on every pair when its interval is due, one pair at a time on the pair's goroutine.
+// Pairs with own open orders are polled at LiveInterval and first. The others wait
+// while the public or account rate headroom is below Reserve, so polling never
+// takes the budget that order placement and cancellation need.
+type Poller struct {
+	comms *Comms
+
+	mu           sync.Mutex
+	pairs        map[string]*polledPair
+	Interval     time.Duration //default per pair interval
+	LiveInterval time.Duration
+	Reserve      float64
+	stop         chan struct{}
+
+	infoLog *log.Logger
+	warnLog *log.Logger
+	errLog  *log.Logger
+}
+
+type polledPair struct {
+	m        *MarketPair
+	interval time.Duration
+	lastPoll time.Time
+}
+
+func NewPoller(c *Comms, info *log.Logger, warn *log.Logger, er *log.Logger) *Poller {
+	return &Poller{comms: c, pairs: make(map[string]*polledPair), Interval: DefaultPollInterval,
+		LiveInterval: DefaultLivePollInterval, Reserve: DefaultPollReserve,
+		infoLog: info, warnLog: warn, errLog: er}
+}
+
+// Add polls a registered pair every interval; zero uses Interval. It can be called while running.
+func (o *Poller) Add(m *MarketPair, interval time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pairs[m.pair] = &polledPair{m: m, interval: interval}
+}
+
+func (o *Poller) Remove(pair string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.pairs, pair)
+}
+
+// SetInterval changes the interval of a polled pair; zero uses Interval.
+func (o *Poller) SetInterval(pair string, interval time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if p, found := o.pairs[pair]; found {
+		p.interval = interval
+	}
+}
+
+func (o *Poller) Start() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.stop != nil {
+		return
+	}
+	o.stop = make(chan struct{})
+	go o.run(o.stop)
+}
+
+// Stop ends polling after the poll in progress.
+func (o *Poller) Stop() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.stop != nil {
+		close(o.stop)
+		o.stop = nil
+	}
+}
+
+func (o *Poller) run(stop chan struct{}) {
+	t := time.NewTicker(pollTick)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-t.C:
+			for _, p := range o.due(now) {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				o.poll(p)
+			}
+		}
+	}
+}
+
+// live is true if the pair has own open orders.
+func (o *polledPair) live() bool {
+	s := o.m.Snapshot()
+	return s != nil && len(s.MyOrders) > 0
+}
+
+type duePair struct {
+	*polledPair
+	live    bool
+	overdue time.Duration
+}
+
+// due lists the pairs to poll now: live ones first, then the longest overdue.
+func (o *Poller) due(now time.Time) []duePair {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var l []duePair
+	for _, p := range o.pairs {
+		d := duePair{polledPair: p, live: p.live()}
+		interval := p.interval
+		if interval == 0 {
+			interval = o.Interval
+		}
+		if d.live && interval > o.LiveInterval {
+			interval = o.LiveInterval
+		}
+		//from the last poll, so a pair turning live is due at once
+		d.overdue = now.Sub(p.lastPoll.Add(interval))
+		if d.overdue >= 0 {
+			l = append(l, d)
+		}
+	}
+	sort.Slice(l, func(i, j int) bool {
+		if l[i].live != l[j].live {
+			return l[i].live
+		}
+		return l[i].overdue > l[j].overdue
+	})
+	return l
+}
+
+func (o *Poller) poll(p duePair) {
+	//only the classes of UpdateMarketHttp, a ban on order calls doesn't stop polling
+	if until := o.comms.ClassRateLimitedUntil(ClassPublic, ClassAccount); time.Now().Before(until) {
+		return //a request now would only fail with ErrRateLimited
+	}
+	if !p.live && (o.comms.RateHeadroom(ClassPublic) < o.Reserve || o.comms.RateHeadroom(ClassAccount) < o.Reserve) {
+		o.infoLog.Println(p.m.pair, "poll deferred, rate headroom below reserve")
+		return
+	}
+	p.m.Do(func(m *MarketPair) { m.UpdateMarketHttp() }) //errors are logged by the pair
+	o.mu.Lock()
+	p.lastPoll = time.Now()
+	o.mu.Unlock()
+}
@@ -0,0 +1,117 @@
+package market
+
+import (
+	"encoding/json"
+
+	"github.com/shopspring/decimal"
+)
+
+//Private websocket channels. They need an authorized socket and carry no market_id
+//filter: every packet is for the whole account. Orders and fills are routed to the owning
+//pairs, balances go to the Comms balance book.
+
+var privateChannels = []string{"open_order", "order_history", "trade_history", "balance"}
+
+type privateOrders struct {
+	Channel string         `json:"channel"`
+	Reset   bool           `json:"reset"`
+	Data    []CurrentOrder `json:"data"`
+}
+
+type privateTrades struct {
+	Channel string    `json:"channel"`
+	Reset   bool      `json:"reset"`
+	Data    []MyTrade `json:"data"`
+}
+
+type privateBalance struct {
+	Channel string `json:"channel"`
+	Reset   bool   `json:"reset"`
+	Data    map[string]struct {
+		Available string `json:"available"`
+		Total     string `json:"total"`
+	} `json:"data"`
+}
+
+// SubscribePrivate subscribes the own order, fill and balance channels.
+// It is sent once the socket authorization is acknowledged.
+func (o *Comms) SubscribePrivate() {
+	for _, ch := range privateChannels {
+		command := `{
+	"type": "subscribe",
+	"channel": "` + ch + `"
+	}`
+		o.send(command)
+	}
+}
+
+func (o *Comms) receiveOrders(e Envelope, message []byte) {
+	o.infoLog.Println("Recieved orders: " + string(message))
+	var d privateOrders
+	if err := json.Unmarshal(message, &d); err != nil {
+		o.errLog.Println("error unmarshaling orders: ", err)
+		return
+	}
+	//order_history only reports closed orders, it is never a full open_order snapshot
+	reset := d.Reset && d.Channel == "open_order"
+	byPair := make(map[string][]CurrentOrder)
+	for _, c := range d.Data {
+		byPair[c.MarketID] = append(byPair[c.MarketID], c)
+	}
+	for p, m := range o.pairs() {
+		if l, found := byPair[p]; found || reset {
+			m.PushMyOrders(l, reset)
+		}
+	}
+}
+
+func (o *Comms) receiveTrades(e Envelope, message []byte) {
+	o.infoLog.Println("Recieved trades: " + string(message))
+	var d privateTrades
+	if err := json.Unmarshal(message, &d); err != nil {
+		o.errLog.Println("error unmarshaling trades: ", err)
+		return
+	}
+	if d.Reset {
+		return //old fills, already reflected in the order and balance snapshots
+	}
+	byPair := make(map[string][]MyTrade)
+	for _, t := range d.Data {
+		byPair[t.MarketID] = append(byPair[t.MarketID], t)
+		if s, err := o.GetMarketSpec(t.MarketID); err == nil {
+			o.balances.fill(s, t)
+		}
+	}
+	for p, l := range byPair {
+		if m, found := o.pair(p); found {
+			m.PushMyTrades(l)
+		}
+	}
+}
+
+func (o *Comms) receiveBalance(e Envelope, message []byte) {
+	o.infoLog.Println("Recieved balance: " + string(message))
+	var d privateBalance
+	if err := json.Unmarshal(message, &d); err != nil {
+		o.errLog.Println("error unmarshaling balance: ", err)
+		return
+	}
+	coins := make(map[string]CoinBalance)
+	for c, b := range d.Data {
+		total, err1 := decimal.NewFromString(b.Total)
+		avail, err2 := decimal.NewFromString(b.Available)
+		if err1 != nil || err2 != nil {
+			o.errLog.Println("error in reading total/avail:", c, b.Total, b.Available)
+			continue
+		}
+		coins[c] = CoinBalance{Total: total, Avail: avail}
+	}
+	if d.Reset {
+		o.balances.load(coins)
+		o.balances.setStreamed(true)
+		return
+	}
+	for c, b := range coins {
+		o.balances.set(c, b)
+	}
+}
@@ -0,0 +1,204 @@
+package market
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// EndpointClass groups ProBit endpoints sharing one request budget.
+type EndpointClass int
+
+const (
+	ClassPublic  EndpointClass = iota //market, order_book, trade
+	ClassOrder                        //new_order, cancel_order
+	ClassAccount                      //open_order, balance, trade_history
+	ClassAuth                         //token
+)
+
+func (o EndpointClass) String() string {
+	switch o {
+	case ClassPublic:
+		return "public"
+	case ClassOrder:
+		return "order"
+	case ClassAccount:
+		return "account"
+	case ClassAuth:
+		return "auth"
+	}
+	return "unknown"
+}
+
+// RateBudget is a token bucket: PerSecond refill rate and Burst capacity.
+type RateBudget struct {
+	PerSecond float64
+	Burst     int
+}
+
+func defaultRateBudgets() map[EndpointClass]RateBudget {
+	return map[EndpointClass]RateBudget{
+		ClassPublic:  {PerSecond: 10, Burst: 20},
+		ClassOrder:   {PerSecond: 5, Burst: 10},
+		ClassAccount: {PerSecond: 3, Burst: 6},
+		ClassAuth:    {PerSecond: 0.2, Burst: 2},
+	}
+}
+
+// defaultRetryAfter is used when a 429 comes without a usable Retry-After header.
+const defaultRetryAfter = 120 * time.Second
+
+type tokenBucket struct {
+	budget       RateBudget
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+}
+
+func (o *tokenBucket) refill(now time.Time) {
+	o.tokens = math.Min(float64(o.budget.Burst), o.tokens+now.Sub(o.last).Seconds()*o.budget.PerSecond)
+	o.last = now
+}
+
+// rateLimiter is shared by all REST calls of a Comms.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[EndpointClass]*tokenBucket
+}
+
+// newRateLimiter uses the valid budgets of the overrides and the defaults for the rest.
+func newRateLimiter(budgets map[EndpointClass]RateBudget) *rateLimiter {
+	l := rateLimiter{buckets: make(map[EndpointClass]*tokenBucket)}
+	now := time.Now()
+	for c, b := range defaultRateBudgets() {
+		if o, found := budgets[c]; found && o.PerSecond > 0 && o.Burst >= 1 {
+			b = o //an unusable override would never refill, the default is kept
+		}
+		l.buckets[c] = &tokenBucket{budget: b, tokens: float64(b.Burst), last: now}
+	}
+	return &l
+}
+
+// wait blocks until a request of class c may be sent and takes a token for it.
+// While the class is banned it returns an ErrRateLimited error at once: a ban lasts
+// minutes, and the callers run on the pair and websocket goroutines.
+func (o *rateLimiter) wait(c EndpointClass) error {
+	for {
+		o.mu.Lock()
+		b := o.buckets[c]
+		now := time.Now()
+		b.refill(now)
+		if now.Before(b.blockedUntil) {
+			until := b.blockedUntil
+			o.mu.Unlock()
+			return &APIError{Code: ErrRateLimited.Code, Message: fmt.Sprint(c, " requests blocked until ", until.Format(time.RFC3339)),
+				HTTPStatus: http.StatusTooManyRequests, Retryable: true}
+		}
+		if b.tokens >= 1 {
+			b.tokens--
+			o.mu.Unlock()
+			return nil
+		}
+		d := time.Duration((1 - b.tokens) / b.budget.PerSecond * float64(time.Second))
+		o.mu.Unlock()
+		time.Sleep(d)
+	}
+}
+
+// block stops class c until t and empties its bucket, e.g. after a 429.
+func (o *rateLimiter) block(c EndpointClass, t time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	b := o.buckets[c]
+	if t.After(b.blockedUntil) {
+		b.blockedUntil = t
+	}
+	b.tokens = 0
+}
+
+func (o *rateLimiter) headroom(c EndpointClass) float64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	b := o.buckets[c]
+	now := time.Now()
+	if now.Before(b.blockedUntil) {
+		return 0
+	}
+	b.refill(now)
+	return b.tokens / float64(b.budget.Burst)
+}
+
+// blockedUntil is the end of the latest ban of classes, of any class without them.
+func (o *rateLimiter) blockedUntil(classes ...EndpointClass) time.Time {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var t time.Time
+	for c, b := range o.buckets {
+		if len(classes) > 0 && !hasClass(classes, c) {
+			continue
+		}
+		if b.blockedUntil.After(t) {
+			t = b.blockedUntil
+		}
+	}
+	return t
+}
+
+func hasClass(classes []EndpointClass, c EndpointClass) bool {
+	for _, x := range classes {
+		if x == c {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfter reads the Retry-After header, either seconds or an HTTP date.
+func retryAfter(h http.Header) time.Duration {
+	v := h.Get("Retry-After")
+	if s, err := strconv.Atoi(v); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return defaultRetryAfter
+}
+
+// RateHeadroom is the share of the class budget still available, 0 (blocked or
+// exhausted) to 1 (full burst). Strategies can back off well before a 429.
+func (o *Comms) RateHeadroom(c EndpointClass) float64 {
+	return o.limiter.headroom(c)
+}
+
+// RateLimitedUntil is the end of the latest Retry-After ban on any class.
+func (o *Comms) RateLimitedUntil() time.Time {
+	return o.limiter.blockedUntil()
+}
+
+// ClassRateLimitedUntil is the end of the latest Retry-After ban on one of classes.
+func (o *Comms) ClassRateLimitedUntil(classes ...EndpointClass) time.Time {
+	return o.limiter.blockedUntil(classes...)
+}
+
+// do sends every REST request: it waits for the class budget and turns a 429
+// into a ban of the class for the Retry-After period. During a ban it fails with
+// ErrRateLimited without sending.
+func (o *Comms) do(c EndpointClass, req *http.Request) (*http.Response, error) {
+	if err := o.limiter.wait(c); err != nil {
+		return nil, err
+	}
+	resp, err := o.opts.HTTPClient.Do(req)
+	if err != nil {
+		return resp, err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		until := time.Now().Add(retryAfter(resp.Header))
+		o.limiter.block(c, until)
+		o.errLog.Println("Rate Timeout:", c, until, time.Now())
+	}
+	return resp, nil
+}
@@ -0,0 +1,82 @@
+package market
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryAfterBanFailsFast(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	q := log.New(ioutil.Discard, "", 0)
+	c := &Comms{opts: CommsOptions{RestURL: srv.URL}.withDefaults(), limiter: newRateLimiter(nil), infoLog: q, warnLog: q, errLog: q}
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	resp, err := c.do(ClassPublic, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if until := c.RateLimitedUntil(); time.Until(until) < 110*time.Second {
+		t.Fatalf("banned until %v, want about 120s from now", until)
+	}
+	if until := c.ClassRateLimitedUntil(ClassOrder, ClassAuth); !until.IsZero() {
+		t.Errorf("order and auth banned until %v, want no ban", until)
+	}
+
+	start := time.Now()
+	_, err = c.do(ClassPublic, req)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("request during the ban: %v, want ErrRateLimited", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("request during the ban took %v", d)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("%d requests reached the server, want 1", n)
+	}
+	//other classes keep working
+	if resp, err = c.do(ClassOrder, req); err != nil {
+		t.Errorf("order class during a public ban: %v", err)
+	} else {
+		resp.Body.Close()
+	}
+}
+
+// Budgets that would never refill are replaced by the defaults instead of spinning.
+func TestInvalidRateBudgets(t *testing.T) {
+	l := newRateLimiter(map[EndpointClass]RateBudget{ClassPublic: {PerSecond: 0, Burst: 5}, ClassOrder: {PerSecond: 5, Burst: 0},
+		ClassAccount: {PerSecond: 100, Burst: 1}})
+	d := defaultRateBudgets()
+	for _, c := range []EndpointClass{ClassPublic, ClassOrder} {
+		if b := l.buckets[c].budget; b != d[c] {
+			t.Errorf("%v budget %+v, want the default %+v", c, b, d[c])
+		}
+	}
+	if b := l.buckets[ClassAccount].budget; b.PerSecond != 100 || b.Burst != 1 {
+		t.Errorf("valid override replaced: %+v", b)
+	}
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 25; i++ {
+			l.wait(ClassPublic)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting on a public budget without refill rate hangs")
+	}
+}
@@ -0,0 +1,71 @@
+package market
+
+import (
+	"time"
+)
+
+// DefaultTimerInterval is how often Strategy.OnTimer is called.
+const DefaultTimerInterval = 10 * time.Second
+
+// Strategy reacts to the events of a MarketPair. Every hook runs on the pair's
+// goroutine (see loop.go), so it can read the pair and place or cancel orders directly.
+type Strategy interface {
+	// OnBookUpdate follows a trusted order book update, from the websocket or HTTP.
+	OnBookUpdate(m *MarketPair)
+	// OnOwnOrderUpdate gets own orders changed by the private channels; closed ones
+	// are already removed from MyOrders.
+	OnOwnOrderUpdate(m *MarketPair, orders []CurrentOrder)
+	// OnFill gets new fills of own orders, each once: pushed by the private channels,
+	// or read from /trade_history when polled orders show a fill (see fills.go).
+	OnFill(m *MarketPair, trades []MyTrade)
+	// OnBalanceChange gets the new balance of the pair's coin or quote currency.
+	OnBalanceChange(m *MarketPair, currency string, b CoinBalance)
+	// OnTimer is called every TimerInterval, with or without market activity.
+	OnTimer(m *MarketPair, t time.Time)
+	// OnDisconnect follows a websocket loss; the book is untrusted until the next snapshot.
+	OnDisconnect(m *MarketPair)
+}
+
+// TickerStrategy is implemented by strategies that also want every new ticker.
+type TickerStrategy interface {
+	OnTicker(m *MarketPair, t TickerStats)
+}
+
+// BaseStrategy ignores every event; embed it to implement only some hooks.
+type BaseStrategy struct{}
+
+func (BaseStrategy) OnBookUpdate(m *MarketPair)                                    {}
+func (BaseStrategy) OnOwnOrderUpdate(m *MarketPair, orders []CurrentOrder)         {}
+func (BaseStrategy) OnFill(m *MarketPair, trades []MyTrade)                        {}
+func (BaseStrategy) OnBalanceChange(m *MarketPair, currency string, b CoinBalance) {}
+func (BaseStrategy) OnTimer(m *MarketPair, t time.Time)                            {}
+func (BaseStrategy) OnDisconnect(m *MarketPair)                                    {}
+
+// FuncStrategy calls f on book and own order updates, like the old single callback.
+type FuncStrategy func(m *MarketPair)
+
+func (f FuncStrategy) OnBookUpdate(m *MarketPair) { f(m) }
+func (f FuncStrategy) OnOwnOrderUpdate(m *MarketPair, orders []CurrentOrder) {
+	if m.BookTrusted() {
+		f(m)
+	}
+}
+func (FuncStrategy) OnFill(m *MarketPair, trades []MyTrade)                        {}
+func (FuncStrategy) OnBalanceChange(m *MarketPair, currency string, b CoinBalance) {}
+func (FuncStrategy) OnTimer(m *MarketPair, t time.Time)                            {}
+func (FuncStrategy) OnDisconnect(m *MarketPair)                                    {}
+
+// SetTimerInterval changes how often OnTimer is called; zero stops it.
+func (o *MarketPair) SetTimerInterval(d time.Duration) {
+	o.post(func() { o.loop.interval = d })
+}
+
+// PushBalances passes balance changes of the pair's currencies to the strategy.
+func (o *MarketPair) PushBalances(changed map[string]CoinBalance) {
+	for _, c := range []string{o.Coin, o.Quote} {
+		if b, found := changed[c]; found {
+			c := c
+			o.post(func() { o.strategy.OnBalanceChange(o, c, b) })
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package market
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+//marketdata subscriptions. Comms keeps the wanted set with each pair's filters and
+//sends it again after every reconnect. ProBit doesn't reply to a subscribe command;
+//the first packet of the market acknowledges it, and a subscription left pending for
+//SubscribeTimeout is sent again.
+
+const (
+	FilterTicker       = "ticker"
+	FilterRecentTrades = "recent_trades"
+	FilterOrderBooks   = "order_books"
+	FilterOrderBooksL0 = "order_books_l0"
+	FilterOrderBooksL1 = "order_books_l1"
+	FilterOrderBooksL2 = "order_books_l2"
+	FilterOrderBooksL3 = "order_books_l3"
+	FilterOrderBooksL4 = "order_books_l4"
+)
+
+// DefaultFilters is used for pairs without SetFilters. MarketPair keeps its Book
+// from order_books, its Tape from recent_trades and its Ticker from ticker.
+var DefaultFilters = []string{FilterOrderBooks, FilterRecentTrades, FilterTicker}
+
+var ErrUnknownFilter = errors.New("unknown marketdata filter")
+
+// ErrNoBookFilter rejects filters without order_books. The aggregated
+// order_books_l0..l4 levels aren't decoded, so they can't replace it.
+var ErrNoBookFilter = errors.New("marketdata filters without order_books")
+
+func validFilter(f string) bool {
+	switch f {
+	case FilterTicker, FilterRecentTrades, FilterOrderBooks, FilterOrderBooksL0, FilterOrderBooksL1,
+		FilterOrderBooksL2, FilterOrderBooksL3, FilterOrderBooksL4:
+		return true
+	}
+	return false
+}
+
+// Subscription is the state of one market's marketdata subscription.
+type Subscription struct {
+	Filters   []string
+	Requested time.Time //last subscribe command; zero until the socket is connected
+	Acked     time.Time //first packet after Requested
+	live      bool      //the server has the subscription, an unsubscribe is needed to renew it
+}
+
+// Pending is true while a sent subscribe command has no packet yet.
+func (o Subscription) Pending() bool {
+	return !o.Requested.IsZero() && o.Acked.Before(o.Requested)
+}
+
+type marketdataCommand struct {
+	Type     string   `json:"type"`
+	Channel  string   `json:"channel"`
+	Interval int      `json:"interval,omitempty"`
+	MarketID string   `json:"market_id"`
+	Filter   []string `json:"filter,omitempty"`
+}
+
+func (o *Comms) sendMarketdata(c marketdataCommand) {
+	b, _ := json.Marshal(c)
+	o.send(string(b))
+}
+
+// SetFilters selects the marketdata filters of a pair; they must include
+// order_books. A subscribed pair is subscribed again with the new filters.
+func (o *Comms) SetFilters(pair string, filters ...string) error {
+	if len(filters) == 0 {
+		filters = DefaultFilters
+	}
+	book := false
+	for _, f := range filters {
+		if !validFilter(f) {
+			o.errLog.Println("ERROR:", pair, f, ErrUnknownFilter)
+			return ErrUnknownFilter
+		}
+		book = book || f == FilterOrderBooks
+	}
+	if !book {
+		o.errLog.Println("ERROR:", pair, filters, ErrNoBookFilter)
+		return ErrNoBookFilter
+	}
+	o.subMu.Lock()
+	o.filters[pair] = append([]string{}, filters...)
+	_, subscribed := o.subs[pair]
+	o.subMu.Unlock()
+	if subscribed {
+		o.Subscribe(pair)
+	}
+	return nil
+}
+
+// Subscribe adds the pair to the wanted subscriptions and sends the command if the
+// socket is up. An already subscribed pair is unsubscribed first, so the server
+// answers with a fresh reset packet.
+func (o *Comms) Subscribe(pair string) {
+	o.subMu.Lock()
+	filters, found := o.filters[pair]
+	if !found {
+		filters = DefaultFilters
+	}
+	s, found := o.subs[pair]
+	if !found {
+		s = &Subscription{}
+		o.subs[pair] = s
+	}
+	s.Filters = filters
+	renew := s.live
+	connected := o.ConnState() >= ConnConnected
+	if connected {
+		s.Requested = time.Now()
+		s.live = true
+	}
+	o.subMu.Unlock()
+	if !connected {
+		o.infoLog.Println(pair, "subscription waits for the socket")
+		return
+	}
+	if renew {
+		o.sendMarketdata(marketdataCommand{Type: "unsubscribe", Channel: "marketdata", MarketID: pair})
+	}
+	o.sendMarketdata(marketdataCommand{Type: "subscribe", Channel: "marketdata", Interval: 100, MarketID: pair, Filter: filters})
+}
+
+func (o *Comms) UnSubscribe(pair string) {
+	o.subMu.Lock()
+	s, found := o.subs[pair]
+	delete(o.subs, pair)
+	o.subMu.Unlock()
+	if found && s.live {
+		o.sendMarketdata(marketdataCommand{Type: "unsubscribe", Channel: "marketdata", MarketID: pair})
+	}
+}
+
+// Subscriptions is a copy of the wanted subscriptions by market.
+func (o *Comms) Subscriptions() map[string]Subscription {
+	o.subMu.Lock()
+	defer o.subMu.Unlock()
+	m := make(map[string]Subscription, len(o.subs))
+	for p, s := range o.subs {
+		m[p] = *s
+	}
+	return m
+}
+
+// ackSubscription records a marketdata packet; false if the market isn't subscribed,
+// e.g. packets still in flight after an unsubscribe.
+func (o *Comms) ackSubscription(pair string) bool {
+	o.subMu.Lock()
+	defer o.subMu.Unlock()
+	s, found := o.subs[pair]
+	if !found {
+		return false
+	}
+	if s.Pending() {
+		s.Acked = time.Now()
+		o.infoLog.Println(pair, "subscription acknowledged after", s.Acked.Sub(s.Requested))
+	}
+	return true
+}
+
+// resubscribeAll sends every wanted subscription on a new socket.
+func (o *Comms) resubscribeAll() {
+	o.subMu.Lock()
+	var pairs []string
+	for p, s := range o.subs {
+		s.live = false
+		pairs = append(pairs, p)
+	}
+	o.subMu.Unlock()
+	o.warnLog.Println("Subscribing pairs:", len(pairs))
+	for _, p := range pairs {
+		time.Sleep(time.Millisecond * 10)
+		o.Subscribe(p)
+	}
+}
+
+// checkSubscriptions sends again the subscriptions pending for SubscribeTimeout.
+func (o *Comms) checkSubscriptions() {
+	o.subMu.Lock()
+	var late []string
+	for p, s := range o.subs {
+		if s.Pending() && time.Since(s.Requested) > o.opts.SubscribeTimeout {
+			late = append(late, p)
+		}
+	}
+	o.subMu.Unlock()
+	for _, p := range late {
+		o.warnLog.Println(p, "subscription not acknowledged, sending again")
+		o.Subscribe(p)
+	}
+}
+
+// AddPair registers a pair and subscribes it; it can be called at any time.
+// UnregisterPair drops it again.
+func (o *Comms) AddPair(p string, m marketPairer) error {
+	if err := o.RegisterPair(p, m); err != nil {
+		return err
+	}
+	//the open_order snapshot may have passed already; the load is queued before
+	//the first marketdata packet, so the strategy starts with the resting orders
+	m.LoadMyOrders()
+	o.Subscribe(p)
+	return nil
+}
@@ -0,0 +1,313 @@
+package market
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sacOO7/gowebsocket"
+)
+
+//The supervisor owns the websocket: it dials, authorizes and subscribes, pings the
+//server, and on any loss (read error, heartbeat timeout, "ping timeout" from ProBit)
+//reconnects with exponential backoff and jitter. Every step is reported as a ConnEvent.
+
+type ConnState int
+
+const (
+	ConnDisconnected ConnState = iota
+	ConnConnecting
+	ConnConnected
+	ConnAuthorized
+)
+
+func (o ConnState) String() string {
+	switch o {
+	case ConnDisconnected:
+		return "disconnected"
+	case ConnConnecting:
+		return "connecting"
+	case ConnConnected:
+		return "connected"
+	case ConnAuthorized:
+		return "authorized"
+	}
+	return "unknown"
+}
+
+type ConnEvent struct {
+	State   ConnState
+	Attempt int   //reconnect attempt, 0 on the first connection
+	Err     error //why the connection was lost or the attempt failed
+	Time    time.Time
+}
+
+// socketLost is sent by the handlers of socket generation gen.
+type socketLost struct {
+	gen int
+	err error
+}
+
+// OnConnState registers f for connection events. f runs on the supervisor or
+// socket goroutine and must not block.
+func (o *Comms) OnConnState(f func(e ConnEvent)) {
+	o.sockMu.Lock()
+	defer o.sockMu.Unlock()
+	o.connObservers = append(o.connObservers, f)
+}
+
+func (o *Comms) ConnState() ConnState {
+	o.sockMu.Lock()
+	defer o.sockMu.Unlock()
+	return o.connState
+}
+
+func (o *Comms) setConnState(s ConnState, attempt int, err error) {
+	o.sockMu.Lock()
+	o.connState = s
+	if s == ConnAuthorized {
+		attempt = o.connAttempt
+	} else {
+		o.connAttempt = attempt
+	}
+	observers := append([]func(ConnEvent){}, o.connObservers...)
+	o.sockMu.Unlock()
+	e := ConnEvent{State: s, Attempt: attempt, Err: err, Time: time.Now()}
+	o.warnLog.Println("socket", s, "attempt:", attempt, "err:", err)
+	for _, f := range observers {
+		f(e)
+	}
+}
+
+// send writes a text frame on the current socket.
+func (o *Comms) send(text string) {
+	o.sockMu.Lock()
+	s := o.socket
+	o.sockMu.Unlock()
+	if s == nil {
+		o.errLog.Println("socket not connected, dropped:", text)
+		return
+	}
+	s.SendText(text)
+}
+
+// conn is the connection of the current socket, nil before the first dial. Only
+// the gorilla methods safe next to the socket's reader are used on it: Close and WriteControl.
+func (o *Comms) conn() *websocket.Conn {
+	o.sockMu.Lock()
+	defer o.sockMu.Unlock()
+	if o.socket == nil {
+		return nil
+	}
+	return o.socket.Conn
+}
+
+// touch records traffic from the server for the heartbeat check.
+func (o *Comms) touch() {
+	o.sockMu.Lock()
+	o.lastRX = time.Now()
+	o.sockMu.Unlock()
+}
+
+func (o *Comms) OpenSocket() {
+	o.sockMu.Lock()
+	o.toBeClosed = false
+	o.lost = make(chan socketLost, 4)
+	o.done = make(chan struct{})
+	gen := o.sockGen
+	o.sockMu.Unlock()
+	o.setConnState(ConnConnecting, 0, nil)
+	if err := o.dial(0); err != nil {
+		//let the supervisor retry
+		o.lost <- socketLost{gen: gen, err: err}
+	}
+	go o.supervise()
+}
+
+// CloseSocket closes the socket and stops reconnecting. It does nothing if the
+// socket was never opened or is already closed.
+func (o *Comms) CloseSocket() {
+	o.sockMu.Lock()
+	if o.done == nil || o.toBeClosed {
+		o.sockMu.Unlock()
+		return
+	}
+	o.toBeClosed = true
+	close(o.done)
+	o.sockMu.Unlock()
+	//not socket.Close(): it races with the reader goroutine over the Socket fields
+	if c := o.conn(); c != nil {
+		c.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		c.Close()
+	}
+	o.setConnState(ConnDisconnected, 0, nil)
+}
+
+func (o *Comms) closing() bool {
+	o.sockMu.Lock()
+	defer o.sockMu.Unlock()
+	return o.toBeClosed
+}
+
+// dial opens a new socket generation, authorizes it and subscribes every pair.
+func (o *Comms) dial(attempt int) error {
+	o.sockMu.Lock()
+	gen := o.sockGen + 1
+	o.sockMu.Unlock()
+
+	s := gowebsocket.New(o.opts.WsURL)
+	s.WebsocketDialer.HandshakeTimeout = o.opts.DialTimeout
+	var connectErr error
+	s.OnConnected = func(socket gowebsocket.Socket) {
+		o.warnLog.Println("Connected to server")
+	}
+	s.OnConnectError = func(err error, socket gowebsocket.Socket) {
+		o.errLog.Println("Recieved connect error ", err)
+		connectErr = err
+	}
+	s.OnTextMessage = func(message string, socket gowebsocket.Socket) {
+		o.touch()
+		o.dispatch([]byte(message))
+	}
+	s.OnBinaryMessage = func(data []byte, socket gowebsocket.Socket) {
+		o.infoLog.Println("Recieved binary data ", data)
+	}
+	s.OnPingReceived = func(data string, socket gowebsocket.Socket) {
+		o.touch()
+	}
+	s.OnPongReceived = func(data string, socket gowebsocket.Socket) {
+		o.touch()
+	}
+	s.OnDisconnected = func(err error, socket gowebsocket.Socket) {
+		if err == nil {
+			err = errors.New("closed")
+		}
+		select {
+		case o.lost <- socketLost{gen: gen, err: err}:
+		default: //a loss of this generation is already queued
+		}
+	}
+	s.Connect()
+	if s.Conn == nil {
+		if connectErr == nil {
+			connectErr = errors.New("not connected")
+		}
+		return connectErr
+	}
+
+	o.sockMu.Lock()
+	o.socket = &s
+	o.sockGen = gen
+	o.lastRX = time.Now()
+	o.sockMu.Unlock()
+	o.setConnState(ConnConnected, attempt, nil)
+
+	o.AuthSocket()
+	o.resubscribeAll()
+	return nil
+}
+
+// dropSocket closes the connection; its reader then reports the loss.
+func (o *Comms) dropSocket(reason string) {
+	o.errLog.Println("dropping socket:", reason)
+	if c := o.conn(); c != nil {
+		c.Close()
+	}
+}
+
+// reauthSocket sends the authorization again once keepAuth has a valid token,
+// e.g. after the server rejected an expired one.
+func (o *Comms) reauthSocket() {
+	time.Sleep(time.Second)
+	for o.NeedsAuth() {
+		time.Sleep(500 * time.Millisecond)
+	}
+	if !o.closing() {
+		o.AuthSocket()
+	}
+}
+
+// backoff is the wait before reconnect attempt n: exponential, capped, with equal jitter.
+func (o *Comms) backoff(n int) time.Duration {
+	d := o.opts.ReconnectMin
+	for i := 1; i < n && d < o.opts.ReconnectMax; i++ {
+		d *= 2
+	}
+	if d > o.opts.ReconnectMax {
+		d = o.opts.ReconnectMax
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (o *Comms) supervise() {
+	ping := time.NewTicker(o.opts.PingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-o.done:
+			return
+		case <-ping.C:
+			o.heartbeat()
+			o.checkSubscriptions()
+		case l := <-o.lost:
+			o.sockMu.Lock()
+			stale := l.gen != o.sockGen
+			o.sockMu.Unlock()
+			if stale {
+				continue
+			}
+			if o.closing() {
+				o.warnLog.Println("intentional closing")
+				return
+			}
+			o.errLog.Println("Disconnected from server ", l.err)
+			o.setConnState(ConnDisconnected, 0, l.err)
+			o.balances.setStreamed(false)
+			for _, m := range o.pairs() {
+				m.SocketLost()
+			}
+			if !o.reconnect() {
+				return
+			}
+		}
+	}
+}
+
+// reconnect dials until it succeeds; false if the socket was closed meanwhile.
+func (o *Comms) reconnect() bool {
+	for n := 1; ; n++ {
+		d := o.backoff(n)
+		o.warnLog.Println("reconnecting in", d.Round(time.Millisecond))
+		select {
+		case <-o.done:
+			return false
+		case <-time.After(d):
+		}
+		o.setConnState(ConnConnecting, n, nil)
+		err := o.dial(n)
+		if err == nil {
+			return true
+		}
+		o.setConnState(ConnDisconnected, n, err)
+	}
+}
+
+// heartbeat pings the server and drops a socket that stayed silent too long.
+func (o *Comms) heartbeat() {
+	c := o.conn()
+	o.sockMu.Lock()
+	silent := time.Since(o.lastRX)
+	closing := o.toBeClosed
+	o.sockMu.Unlock()
+	if c == nil || closing {
+		return
+	}
+	if silent > o.opts.HeartbeatTimeout {
+		o.dropSocket("no traffic for " + silent.Round(time.Second).String())
+		return
+	}
+	if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(o.opts.PingInterval)); err != nil {
+		o.dropSocket("ping failed: " + err.Error())
+	}
+}
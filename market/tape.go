@@ -0,0 +1,162 @@
+package market
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// DefaultTapeRetention is how long trades are kept on a MarketPair's tape.
+const DefaultTapeRetention = time.Hour
+
+// TapeTrade is a public trade; Side is the taker's side, so "buy" is aggressive buying.
+type TapeTrade struct {
+	ID       string
+	Price    decimal.Decimal
+	Quantity decimal.Decimal
+	Side     string
+	Time     time.Time
+}
+
+// TapeStats aggregates the trades of a window ending now.
+type TapeStats struct {
+	Window     time.Duration
+	Trades     int
+	BuyVolume  decimal.Decimal //base quantity bought by takers
+	SellVolume decimal.Decimal //base quantity sold by takers
+	Rate       float64         //trades per second
+	VWAP       decimal.Decimal //zero without trades
+	Last       decimal.Decimal //price of the latest trade in the window
+}
+
+// Volume is the traded base quantity of both sides.
+func (o TapeStats) Volume() decimal.Decimal {
+	return o.BuyVolume.Add(o.SellVolume)
+}
+
+// Imbalance is (buy-sell)/(buy+sell) volume, from -1 (only selling) to 1 (only buying).
+func (o TapeStats) Imbalance() float64 {
+	v := o.Volume()
+	if v.IsZero() {
+		return 0
+	}
+	f, _ := o.BuyVolume.Sub(o.SellVolume).Div(v).Float64()
+	return f
+}
+
+// TradeTape is the rolling list of a market's public trades, oldest first. Trades come
+// from the recent_trades websocket filter and the REST backfill, deduplicated by ID.
+// It is safe for concurrent use.
+type TradeTape struct {
+	mu        sync.RWMutex
+	trades    []TapeTrade
+	ids       map[string]bool
+	retention time.Duration
+}
+
+func NewTradeTape(retention time.Duration) *TradeTape {
+	return &TradeTape{ids: make(map[string]bool), retention: retention}
+}
+
+// SetRetention changes how long trades are kept; Stats windows beyond it see only the kept trades.
+func (o *TradeTape) SetRetention(d time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.retention = d
+	o.prune(time.Now().Add(-d))
+}
+
+func (o *TradeTape) Retention() time.Duration {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.retention
+}
+
+// Add stores new trades and drops the ones older than the retention. Unparsable
+// trades are skipped and reported by the returned count.
+func (o *TradeTape) Add(trades []MarketTrade) (bad int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	sorted := true
+	for _, t := range trades {
+		if o.ids[t.ID] {
+			continue
+		}
+		p, e1 := decimal.NewFromString(t.Price)
+		q, e2 := decimal.NewFromString(t.Quantity)
+		if e1 != nil || e2 != nil {
+			bad++
+			continue
+		}
+		if n := len(o.trades); n > 0 && t.Time.Before(o.trades[n-1].Time) {
+			sorted = false
+		}
+		o.ids[t.ID] = true
+		o.trades = append(o.trades, TapeTrade{ID: t.ID, Price: p, Quantity: q, Side: t.Side, Time: t.Time})
+	}
+	if !sorted {
+		sort.SliceStable(o.trades, func(i, j int) bool { return o.trades[i].Time.Before(o.trades[j].Time) })
+	}
+	o.prune(time.Now().Add(-o.retention))
+	return bad
+}
+
+func (o *TradeTape) prune(before time.Time) {
+	i := sort.Search(len(o.trades), func(i int) bool { return !o.trades[i].Time.Before(before) })
+	if i == 0 {
+		return
+	}
+	for _, t := range o.trades[:i] {
+		delete(o.ids, t.ID)
+	}
+	o.trades = append([]TapeTrade{}, o.trades[i:]...)
+}
+
+func (o *TradeTape) Len() int {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return len(o.trades)
+}
+
+// Last is the latest trade; false if the tape is empty.
+func (o *TradeTape) Last() (TapeTrade, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if len(o.trades) == 0 {
+		return TapeTrade{}, false
+	}
+	return o.trades[len(o.trades)-1], true
+}
+
+// Since returns a copy of the trades at or after t, oldest first.
+func (o *TradeTape) Since(t time.Time) []TapeTrade {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	i := sort.Search(len(o.trades), func(i int) bool { return !o.trades[i].Time.Before(t) })
+	return append([]TapeTrade{}, o.trades[i:]...)
+}
+
+// Stats aggregates the trades of the last window.
+func (o *TradeTape) Stats(window time.Duration) TapeStats {
+	s := TapeStats{Window: window}
+	cost := decimal.Zero
+	for _, t := range o.Since(time.Now().Add(-window)) {
+		s.Trades++
+		if t.Side == "buy" {
+			s.BuyVolume = s.BuyVolume.Add(t.Quantity)
+		} else {
+			s.SellVolume = s.SellVolume.Add(t.Quantity)
+		}
+		cost = cost.Add(t.Price.Mul(t.Quantity))
+		s.Last = t.Price
+	}
+	if v := s.Volume(); !v.IsZero() {
+		s.VWAP = cost.Div(v)
+	}
+	if window > 0 {
+		s.Rate = float64(s.Trades) / window.Seconds()
+	}
+	return s
+}
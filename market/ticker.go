@@ -0,0 +1,89 @@
+package market
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Ticker is the 24h summary of the ticker filter, as sent by ProBit.
+type Ticker struct {
+	Time        time.Time `json:"time"`
+	Last        string    `json:"last"`
+	Low         string    `json:"low"`
+	High        string    `json:"high"`
+	Change      string    `json:"change"`
+	BaseVolume  string    `json:"base_volume"`
+	QuoteVolume string    `json:"quote_volume"`
+}
+
+// TickerStats is a parsed Ticker. Change is the absolute 24h change of the last price.
+type TickerStats struct {
+	Time        time.Time
+	Last        decimal.Decimal
+	Low         decimal.Decimal
+	High        decimal.Decimal
+	Change      decimal.Decimal
+	BaseVolume  decimal.Decimal
+	QuoteVolume decimal.Decimal
+}
+
+// parseTicker applies the fields present in t to prev; the missing ones keep their value.
+func parseTicker(prev TickerStats, t Ticker) (TickerStats, error) {
+	s := prev
+	s.Time = t.Time
+	for _, f := range []struct {
+		v string
+		d *decimal.Decimal
+	}{{t.Last, &s.Last}, {t.Low, &s.Low}, {t.High, &s.High}, {t.Change, &s.Change},
+		{t.BaseVolume, &s.BaseVolume}, {t.QuoteVolume, &s.QuoteVolume}} {
+		if f.v == "" {
+			continue //not all fields come in every packet
+		}
+		d, err := decimal.NewFromString(f.v)
+		if err != nil {
+			return prev, err
+		}
+		*f.d = d
+	}
+	return s, nil
+}
+
+// RangePercent is the 24h high-low range relative to the low, a rough volatility.
+func (o TickerStats) RangePercent() decimal.Decimal {
+	if !o.Low.IsPositive() {
+		return decimal.Zero
+	}
+	return o.High.Sub(o.Low).Div(o.Low).Mul(decimal.NewFromInt(100))
+}
+
+// ChangePercent is the 24h change relative to the price 24h ago.
+func (o TickerStats) ChangePercent() decimal.Decimal {
+	open := o.Last.Sub(o.Change)
+	if !open.IsPositive() {
+		return decimal.Zero
+	}
+	return o.Change.Div(open).Mul(decimal.NewFromInt(100))
+}
+
+// Ticker is the latest ticker of the pair; false before the first one.
+func (o *MarketPair) Ticker() (TickerStats, bool) {
+	return o.ticker, !o.ticker.Time.IsZero()
+}
+
+// updateTicker merges the ticker of a packet into the stored one, if it is newer.
+func (o *MarketPair) updateTicker(t Ticker) {
+	if t.Time.IsZero() || !t.Time.After(o.ticker.Time) {
+		return
+	}
+	s, err := parseTicker(o.ticker, t)
+	if err != nil {
+		o.errLog.Println(o.pair, "bad ticker:", err)
+		return
+	}
+	o.ticker = s
+	o.infoLog.Println(o.pair, "ticker: last", s.Last, "range", s.RangePercent().StringFixed(2), "% change", s.ChangePercent().StringFixed(2), "% volume", s.QuoteVolume)
+	if ts, ok := o.strategy.(TickerStrategy); ok {
+		ts.OnTicker(o, s)
+	}
+}
@@ -0,0 +1,31 @@
+package market
+
+import (
+	"testing"
+	"time"
+)
+
+// A packet with only some of the fields keeps the others of the previous ticker.
+func TestParseTickerMerges(t *testing.T) {
+	t0 := time.Now()
+	s, err := parseTicker(TickerStats{}, Ticker{Time: t0, Last: "100", Low: "95", High: "105",
+		Change: "2", BaseVolume: "10", QuoteVolume: "1000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = parseTicker(s, Ticker{Time: t0.Add(time.Second), Last: "101"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Last.String() != "101" || s.QuoteVolume.String() != "1000" || s.High.String() != "105" {
+		t.Fatalf("merged ticker last %v volume %v high %v, want 101 1000 105", s.Last, s.QuoteVolume, s.High)
+	}
+
+	bad, err := parseTicker(s, Ticker{Time: t0.Add(2 * time.Second), Last: "x"})
+	if err == nil {
+		t.Fatal("bad field parsed")
+	}
+	if !bad.Time.Equal(s.Time) || !bad.Last.Equal(s.Last) {
+		t.Fatal("bad packet changed the ticker")
+	}
+}
@@ -0,0 +1,309 @@
+package multiTrade
+
+import (
+	"arbiter/competeTrade"
+	"arbiter/market"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const logDir = "./multilogs/"
+
+// PairConfig is one entry of the pair list file; the fields are the arguments of
+// competeTrade.NewCompeteTrade, zero values are the same as there.
+type PairConfig struct {
+	Pair        string          `json:"pair"`
+	Buy         decimal.Decimal `json:"buy"`
+	Sell        decimal.Decimal `json:"sell"`
+	Quantity    decimal.Decimal `json:"quantity"`
+	USDQuantity decimal.Decimal `json:"usdQuantity"`
+	MaxBalance  decimal.Decimal `json:"maxBalance"`
+	MinBalance  decimal.Decimal `json:"minBalance"`
+	MaxUSDBal   decimal.Decimal `json:"maxUSDBal"`
+	MinUSDBal   decimal.Decimal `json:"minUSDBal"`
+	RoughPrice  decimal.Decimal `json:"roughPrice"`
+	//ticker gates, optional
+	MinQuoteVolume   decimal.Decimal `json:"minQuoteVolume"`
+	MaxRangePercent  decimal.Decimal `json:"maxRangePercent"`
+	MaxChangePercent decimal.Decimal `json:"maxChangePercent"`
+	//safety cage, optional: zero keeps the CompeteTrade defaults, a negative window disables it
+	CageMinutes decimal.Decimal `json:"cageMinutes"`
+	CagePercent decimal.Decimal `json:"cagePercent"`
+	//net spread after fees, optional: the percent to earn per round trip, and the
+	//entry cost of the coin held at start
+	MinNetSpreadPercent decimal.Decimal `json:"minNetSpreadPercent"`
+	EntryPrice          decimal.Decimal `json:"entryPrice"`
+	//ladder mode, optional: levels per side and how far a level may drift before it is
+	//replaced, in percent (zero keeps the CompeteTrade default)
+	Ladder             []competeTrade.LadderLevel `json:"ladder"`
+	LadderDriftPercent decimal.Decimal            `json:"ladderDriftPercent"`
+	//controlled, auto (default), autoSell or none; switchable from the console
+	Operation string `json:"operation"`
+}
+
+// runningPair is a started pair; the trade is only touched on the pair's goroutine.
+type runningPair struct {
+	m *market.MarketPair
+	t *competeTrade.CompeteTrade
+}
+
+// LoadPairs reads the JSON pair list, an array of PairConfig.
+func LoadPairs(file string) ([]PairConfig, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var l []PairConfig
+	if err = json.Unmarshal(b, &l); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, p := range l {
+		if p.Pair == "" {
+			return nil, errors.New("pair list entry without pair")
+		}
+		if seen[p.Pair] {
+			return nil, errors.New("pair listed twice: " + p.Pair)
+		}
+		seen[p.Pair] = true
+	}
+	return l, nil
+}
+
+// MultiCompete runs a CompeteTrade on every pair of the file, or only on the pairs
+// in args if there are any, over one Comms made with opts. protocol is "socket" for
+// the websocket or "http" for the Poller. It returns on interrupt or the x console command.
+func MultiCompete(args []string, file string, protocol string, opts market.CommsOptions) error {
+	if protocol != "socket" && protocol != "http" {
+		return errors.New("unknown protocol: " + protocol)
+	}
+	configs, err := LoadPairs(file)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		configs, err = selectPairs(configs, args)
+		if err != nil {
+			return err
+		}
+	}
+
+	all, err := os.OpenFile(logDir+"all.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	infoLog, warnLog, errLog, err := newLoggers("base", all)
+	if err != nil {
+		return err
+	}
+	cInfo, cWarn, cErr, err := newLoggers("comms", all)
+	if err != nil {
+		return err
+	}
+	c := market.NewComms(opts, cInfo, cWarn, cErr)
+	if c == nil {
+		return errors.New("error creating comms")
+	}
+	if err = c.FetchAllMarketSpecs(); err != nil {
+		errLog.Println("CRIT: error in fetching market specs: ", err)
+		return err
+	}
+	c.StartAuth()
+
+	var poller *market.Poller
+	if protocol == "http" {
+		poller = market.NewPoller(c, cInfo, cWarn, cErr)
+	}
+	pairs := make(map[string]runningPair)
+	for _, p := range configs {
+		r, err := startPair(c, poller, p, all)
+		if err != nil {
+			errLog.Println("error in adding pair: ", p.Pair, err)
+			continue
+		}
+		pairs[p.Pair] = r
+		infoLog.Println("pair added:", p.Pair)
+	}
+	if len(pairs) == 0 {
+		return errors.New("no pair could be started")
+	}
+	//the pairs are registered first, so they all get the open_order snapshot
+	if poller != nil {
+		poller.Start()
+	} else {
+		c.OpenSocket()
+	}
+	warnLog.Println("running", len(pairs), "pairs over", protocol)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	console := make(chan string)
+	go readConsole(console)
+	printHelp()
+loop:
+	for {
+		select {
+		case <-stop:
+			warnLog.Println("exit by interrupt")
+			break loop
+		case line := <-console:
+			if !command(pairs, line, warnLog) {
+				warnLog.Println("exit by command")
+				break loop
+			}
+		}
+	}
+	if poller != nil {
+		poller.Stop()
+	} else {
+		c.CloseSocket()
+	}
+	for pair, r := range pairs {
+		c.UnregisterPair(pair)
+		r.m.Close()
+	}
+	return nil
+}
+
+func selectPairs(configs []PairConfig, names []string) ([]PairConfig, error) {
+	byPair := make(map[string]PairConfig)
+	for _, p := range configs {
+		byPair[p.Pair] = p
+	}
+	var l []PairConfig
+	for _, n := range names {
+		p, found := byPair[n]
+		if !found {
+			return nil, errors.New("pair not in the pair list: " + n)
+		}
+		l = append(l, p)
+	}
+	return l, nil
+}
+
+func readConsole(ch chan<- string) {
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		text, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		ch <- strings.TrimRight(text, "\r\n")
+	}
+}
+
+func printHelp() {
+	fmt.Println("command: x for exit")
+	fmt.Println("command: l to list the pairs and their operation")
+	fmt.Println("command: m PAIR OPERATION to switch to controlled/auto/autoSell/none")
+	fmt.Println("command: c PAIR to confirm the proposed order in controlled operation")
+	fmt.Println("command: ? for this help")
+}
+
+// command runs one console line; false to exit.
+func command(pairs map[string]runningPair, line string, warnLog *log.Logger) bool {
+	split := strings.Fields(line)
+	if len(split) == 0 {
+		return true
+	}
+	var r runningPair
+	if len(split) > 1 {
+		var found bool
+		if r, found = pairs[split[1]]; !found {
+			warnLog.Println("unknown pair:", split[1])
+			return true
+		}
+	}
+	switch {
+	case split[0] == "x":
+		return false
+	case split[0] == "l":
+		for pair, r := range pairs {
+			r.m.Do(func(*market.MarketPair) { fmt.Println(pair, r.t.Operation) })
+		}
+	case split[0] == "m" && len(split) > 2:
+		r.m.Do(func(m *market.MarketPair) {
+			if err := r.t.SetOperation(split[2]); err != nil {
+				warnLog.Println(err)
+			}
+		})
+	case split[0] == "c" && len(split) > 1:
+		r.m.Do(func(m *market.MarketPair) { r.t.Confirm(m) })
+	default:
+		printHelp()
+	}
+	return true
+}
+
+// startPair makes the pair's CompeteTrade and MarketPair and registers it with
+// Comms, subscribed for the websocket or added to the poller.
+func startPair(c *market.Comms, poller *market.Poller, p PairConfig, all io.Writer) (runningPair, error) {
+	var r runningPair
+	info, warn, er, err := newLoggers(p.Pair, all)
+	if err != nil {
+		return r, err
+	}
+	sp, err := c.GetMarketSpec(p.Pair)
+	if err != nil {
+		return r, err
+	}
+	t := competeTrade.NewCompeteTrade(p.Pair, p.Buy, p.Sell, p.Quantity, p.USDQuantity,
+		p.MaxBalance, p.MinBalance, p.MaxUSDBal, p.MinUSDBal, p.RoughPrice, info, warn, er)
+	if t == nil {
+		return r, errors.New("bad compete trade settings")
+	}
+	if p.Operation != "" {
+		if err = t.SetOperation(p.Operation); err != nil {
+			return r, err
+		}
+	}
+	t.MinQuoteVolume = p.MinQuoteVolume
+	t.MaxRangePercent = p.MaxRangePercent
+	t.MaxChangePercent = p.MaxChangePercent
+	t.MinNetSpreadPercent = p.MinNetSpreadPercent
+	t.EntryPrice = p.EntryPrice
+	t.Ladder = p.Ladder
+	if !p.LadderDriftPercent.IsZero() {
+		t.LadderDriftPercent = p.LadderDriftPercent
+	}
+	if !p.CageMinutes.IsZero() {
+		t.CageWindow = time.Duration(p.CageMinutes.Mul(decimal.NewFromInt(int64(time.Minute))).IntPart())
+	}
+	if !p.CagePercent.IsZero() {
+		t.CagePercent = p.CagePercent
+	}
+
+	m := market.NewMarketPair(p.Pair, c, sp, t, info, warn, er)
+	if poller == nil {
+		err = c.AddPair(p.Pair, m)
+	} else if err = c.RegisterPair(p.Pair, m); err == nil {
+		poller.Add(m, 0)
+	}
+	if err != nil {
+		return r, err
+	}
+	return runningPair{m: m, t: t}, nil
+}
+
+// newLoggers opens logDir/name.txt, also written to all; warnings and errors go to stdout too.
+func newLoggers(name string, all io.Writer) (*log.Logger, *log.Logger, *log.Logger, error) {
+	f, err := os.OpenFile(logDir+name+".txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error opening %s logger: %w", name, err)
+	}
+	info := log.New(io.MultiWriter(f, all), "       ", log.Ldate|log.Ltime|log.Lshortfile)
+	warn := log.New(io.MultiWriter(os.Stdout, f, all), "       ", log.Ldate|log.Ltime|log.Lshortfile)
+	er := log.New(io.MultiWriter(os.Stdout, f, all), "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
+	return info, warn, er, nil
+}